@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cenk/backoff"
+)
+
+// parseChildRetryExitCodes ... parses CHILD_RETRY_EXIT_CODES, a CSV of exit codes, ranges like `1-125` and signals like `SIGKILL`
+func parseChildRetryExitCodes(exitCodes string) (ExitCodeRule, error) {
+	rule := ExitCodeRule{Spec: strings.Trim(exitCodes, " ")}
+	err := parseExitCodeMatches(&rule, exitCodes)
+	return rule, err
+}
+
+// childRetryable ... whether an attempt of the child process that exited with exitCode, or was terminated by sig,
+// can be retried: any failure unless CHILD_RETRY_EXIT_CODES lists the ones that can
+func childRetryable(cfg ScuttleConfig, exitCode int, sig syscall.Signal) bool {
+	if exitCode == 0 && sig == 0 {
+		return false
+	}
+	if cfg.ChildRetryExitCodes.Spec == "" {
+		return true
+	}
+	return cfg.ChildRetryExitCodes.matches(exitCode, sig)
+}
+
+// childRetryBackOff ... the waits between attempts of the child process, stopping after CHILD_RETRIES or once ctx is done
+func childRetryBackOff(ctx context.Context, cfg ScuttleConfig) backoff.BackOff {
+	return retryBackOff(ctx, cfg.ChildRetries, cfg.ChildRetryInterval)
+}
+
+// waitToRetryChild ... decides whether the child process is started again after an attempt, waiting for
+// the backoff if it is. Returns false when the attempt was the final one.
+func waitToRetryChild(ctx context.Context, b backoff.BackOff, attempt int, exitCode int, sig syscall.Signal) bool {
+	if config.ChildRetries <= 0 {
+		return false
+	}
+	log(fmt.Sprintf("Child process attempt %d of %d exited with exit code %d", attempt, config.ChildRetries+1, exitCode))
+	if !childRetryable(config, exitCode, sig) {
+		return false
+	}
+	if !terminationTime().IsZero() {
+		log("Not retrying the child process, scuttle received SIGTERM or SIGINT")
+		return false
+	}
+
+	next := b.NextBackOff()
+	if next == backoff.Stop && ctx.Err() != nil {
+		log("Interrupted, not retrying the child process")
+		return false
+	}
+	if next == backoff.Stop {
+		log(fmt.Sprintf("Child process failed after %d attempts, not retrying", attempt))
+		return false
+	}
+	log(fmt.Sprintf("Retrying the child process in %s", next))
+	select {
+	case <-ctx.Done():
+		log("Interrupted, not retrying the child process")
+		return false
+	case <-time.After(next):
+		return true
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// Tests failed attempts are retryable unless CHILD_RETRY_EXIT_CODES leaves them out
+func TestChildRetryable(t *testing.T) {
+	fmt.Println("Starting TestChildRetryable")
+	listed, err := parseChildRetryExitCodes("3, 64-78, SIGKILL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		exitCodes ExitCodeRule
+		exitCode  int
+		sig       syscall.Signal
+		retryable bool
+	}{
+		{ExitCodeRule{}, 0, 0, false},
+		{ExitCodeRule{}, 1, 0, true},
+		{ExitCodeRule{}, 137, syscall.SIGKILL, true},
+		{listed, 0, 0, false},
+		{listed, 3, 0, true},
+		{listed, 70, 0, true},
+		{listed, 4, 0, false},
+		{listed, 137, syscall.SIGKILL, true},
+		{listed, 143, syscall.SIGTERM, false},
+	}
+	for _, test := range tests {
+		cfg := ScuttleConfig{ChildRetries: 2, ChildRetryExitCodes: test.exitCodes}
+		if retryable := childRetryable(cfg, test.exitCode, test.sig); retryable != test.retryable {
+			t.Errorf("'%s', exit code %d: expected retryable to be %v", test.exitCodes.Spec, test.exitCode, test.retryable)
+		}
+	}
+
+	for _, exitCodes := range []string{"3,", "abc", "300", "SIGNOPE"} {
+		if _, err := parseChildRetryExitCodes(exitCodes); err == nil {
+			t.Errorf("%s: expected the exit codes to be rejected", exitCodes)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// childProcess ... a command scuttle started
+type childProcess struct {
+	proc *os.Process
+	// exited ... receives the wait status when scuttle reaps its children, instead of proc.Wait()
+	exited chan syscall.WaitStatus
+	// stopped ... closed once the process has exited
+	stopped chan struct{}
+}
+
+// childProcesses ... the child processes that are running, the signals scuttle receives are passed to all of them
+type childProcesses struct {
+	lock    sync.Mutex
+	running map[int]*childProcess
+}
+
+func newChildProcesses() *childProcesses {
+	return &childProcesses{running: make(map[int]*childProcess)}
+}
+
+// start ... starts a child process, unless ctx is done. It is started under the lock so
+// signals and reaping see it straight away.
+func (c *childProcesses) start(ctx context.Context, binary string, args []string) (*childProcess, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	proc, err := os.StartProcess(binary, args, &os.ProcAttr{
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		// In its own process group, forwarded signals reach everything the child starts
+		Sys: &syscall.SysProcAttr{Setpgid: config.ChildProcessGroup},
+	})
+	if err != nil {
+		return nil, err
+	}
+	child := &childProcess{proc: proc, exited: make(chan syscall.WaitStatus, 1), stopped: make(chan struct{})}
+	c.running[proc.Pid] = child
+	return child, nil
+}
+
+// wait ... waits for the child process to exit, returns its wait status
+func (c *childProcesses) wait(child *childProcess) (syscall.WaitStatus, error) {
+	var status syscall.WaitStatus
+	if config.ReapChildren {
+		status = <-child.exited
+	} else {
+		state, err := child.proc.Wait()
+		if err != nil {
+			return status, err
+		}
+		status = state.Sys().(syscall.WaitStatus)
+	}
+
+	c.lock.Lock()
+	delete(c.running, child.proc.Pid)
+	close(child.stopped)
+	c.lock.Unlock()
+	return status, nil
+}
+
+// list ... the running child processes, calls none under the lock when there are none so no child starts meanwhile
+func (c *childProcesses) list(none func()) []*childProcess {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	children := make([]*childProcess, 0, len(c.running))
+	for _, child := range c.running {
+		children = append(children, child)
+	}
+	if len(children) == 0 && none != nil {
+		none()
+	}
+	return children
+}
+
+// reap ... reaps every exited child, passing the wait status of the child processes to wait().
+// Reaping under the lock so a child started meanwhile is not mistaken for an orphan.
+func (c *childProcesses) reap() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for pid, status := range reapChildren() {
+		child, ok := c.running[pid]
+		if !ok {
+			log(fmt.Sprintf("Reaped orphaned process %d", pid))
+			continue
+		}
+		// Not signalled any more, the pid can be reused once reaped
+		delete(c.running, pid)
+		child.exited <- status
+	}
+}
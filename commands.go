@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// How the commands are run, selected with COMMAND_MODE
+const (
+	// CommandModeSequence ... the commands run one after the other
+	CommandModeSequence = "sequence"
+	// CommandModeParallel ... the commands run at the same time
+	CommandModeParallel = "parallel"
+)
+
+// What happens to the remaining commands when one fails, selected with COMMAND_FAILURE_MODE
+const (
+	// CommandFailureStop ... the remaining commands are skipped
+	CommandFailureStop = "stop"
+	// CommandFailureContinue ... the remaining commands still run
+	CommandFailureContinue = "continue"
+)
+
+// splitCommands ... the commands scuttle runs in order, from COMMANDS_FILE or the arguments split on COMMAND_DELIMITER
+func splitCommands(args []string, cfg ScuttleConfig) ([][]string, error) {
+	if len(cfg.Commands) > 0 {
+		if len(args) > 0 {
+			return nil, errors.New("commands are given both in COMMANDS_FILE and as arguments")
+		}
+		return cfg.Commands, nil
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if cfg.CommandDelimiter == "" {
+		return [][]string{args}, nil
+	}
+
+	commands := make([][]string, 0)
+	command := make([]string, 0)
+	for _, arg := range append(args, cfg.CommandDelimiter) {
+		if arg != cfg.CommandDelimiter {
+			command = append(command, arg)
+			continue
+		}
+		if len(command) == 0 {
+			return nil, fmt.Errorf("command %d is empty, check the use of COMMAND_DELIMITER '%s'", len(commands)+1, cfg.CommandDelimiter)
+		}
+		commands = append(commands, command)
+		command = make([]string, 0)
+	}
+	return commands, nil
+}
+
+// getCommandsFromFile ... reads a JSON array of commands, each an array of the program and its arguments,
+// such as `[["migrate"], ["seed", "--env", "prod"], ["run"]]`
+func getCommandsFromFile(path string) ([][]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read '%s': %w", path, err)
+	}
+	commands := make([][]string, 0)
+	if err := json.Unmarshal(contents, &commands); err != nil {
+		return nil, fmt.Errorf("could not parse '%s': %w", path, err)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("no commands in '%s'", path)
+	}
+	for i, command := range commands {
+		if len(command) == 0 || strings.Trim(command[0], " ") == "" {
+			return nil, fmt.Errorf("command %d in '%s' is empty", i+1, path)
+		}
+	}
+	return commands, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Tests the arguments are split into commands on COMMAND_DELIMITER, or taken from COMMANDS_FILE
+func TestSplitCommands(t *testing.T) {
+	fmt.Println("Starting TestSplitCommands")
+	args := []string{"migrate", ";;", "seed", "--env", "prod", ";;", "run"}
+	tests := []struct {
+		delimiter string
+		expected  [][]string
+	}{
+		{"", [][]string{args}},
+		{";;", [][]string{{"migrate"}, {"seed", "--env", "prod"}, {"run"}}},
+		{"--", [][]string{args}},
+	}
+	for _, test := range tests {
+		commands, err := splitCommands(args, ScuttleConfig{CommandDelimiter: test.delimiter})
+		if err != nil || !reflect.DeepEqual(commands, test.expected) {
+			t.Errorf("'%s': expected %v, got %v (%v)", test.delimiter, test.expected, commands, err)
+		}
+	}
+	for _, invalid := range [][]string{{";;", "run"}, {"migrate", ";;"}, {"migrate", ";;", ";;", "run"}} {
+		if _, err := splitCommands(invalid, ScuttleConfig{CommandDelimiter: ";;"}); err == nil {
+			t.Errorf("%v: expected an empty command to be rejected", invalid)
+		}
+	}
+
+	file, err := ioutil.TempFile("", "scuttle-commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[["migrate"], ["seed", "--env", "prod"], ["run"]]`)
+	file.Close()
+	fromFile, err := getCommandsFromFile(file.Name())
+	if err != nil || !reflect.DeepEqual(fromFile, tests[1].expected) {
+		t.Fatalf("Expected %v, got %v (%v)", tests[1].expected, fromFile, err)
+	}
+	if _, err := splitCommands([]string{"run"}, ScuttleConfig{Commands: fromFile}); err == nil {
+		t.Error("Expected commands in both COMMANDS_FILE and the arguments to be rejected")
+	}
+	for _, contents := range []string{`[]`, `[["migrate"], []]`, `[[""]]`, `["migrate"]`} {
+		ioutil.WriteFile(file.Name(), []byte(contents), 0644)
+		if _, err := getCommandsFromFile(file.Name()); err == nil {
+			t.Errorf("%s: expected the commands to be rejected", contents)
+		}
+	}
+}
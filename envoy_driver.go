@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenk/backoff"
+	"github.com/monzo/typhon"
+)
+
+// ServerInfo ... represents the response from Envoy's server info endpoint
+type ServerInfo struct {
+	State string `json:"state"`
+}
+
+// How often Envoy's stats are checked while draining
+const drainPollInterval = 250 * time.Millisecond
+
+// Clusters Envoy gets its config from, such as Istio's xds-grpc. Their streams stay open, so their
+// requests are never drained.
+var controlPlaneClusters = []string{"xds-grpc", "sds-grpc", "xds_cluster", "ads_cluster"}
+
+func init() {
+	registerDriver("envoy", newEnvoyDriver)
+}
+
+// envoyDriver ... a plain Envoy sidecar, stopped through its own admin API
+type envoyDriver struct {
+	cfg ScuttleConfig
+}
+
+func newEnvoyDriver(cfg ScuttleConfig) SidecarDriver {
+	return &envoyDriver{cfg: cfg}
+}
+
+func (d *envoyDriver) Name() string {
+	return "envoy"
+}
+
+// WaitReady ... also waits for pilot-agent when ISTIO_READY_API is set, an Istio sidecar without
+// ISTIO_QUIT_API is stopped through the Envoy admin API but still becomes ready through the agent
+func (d *envoyDriver) WaitReady(ctx context.Context) error {
+	return waitForIstioReady(ctx, d.cfg)
+}
+
+// Shutdown ... stops Envoy with its admin API, falling back to SIDECAR_QUIT_FALLBACK
+func (d *envoyDriver) Shutdown(ctx context.Context, exitCode int) error {
+	if err := requireLocalAPI("ENVOY_ADMIN_API", d.cfg.EnvoyAdminAPI); err != nil {
+		return err
+	}
+	drainEnvoy(ctx, d.cfg)
+
+	strategies := envoyQuitStrategies(d.cfg, QuitMethodEnvoyAPI, d.cfg.SidecarQuitFallback)
+	return stopSidecar(ctx, d.cfg, "Envoy", envoyLiveURL(d.cfg), strategies)
+}
+
+// pollEnvoy ... polls Envoy's /server_info with backoff until it reports LIVE
+func pollEnvoy(ctx context.Context, cfg ScuttleConfig) error {
+	url := fmt.Sprintf("%s/server_info", cfg.EnvoyAdminAPI)
+	pollCount := 0
+
+	return backoff.Retry(func() error {
+		pollCount++
+		rsp := typhon.NewRequest(ctx, "GET", url, nil).Send().Response()
+
+		info := &ServerInfo{}
+
+		err := rsp.Decode(info)
+		if err != nil {
+			log(fmt.Sprintf("Polling Envoy (%d), error: %s", pollCount, err))
+			return err
+		}
+
+		if info.State != "LIVE" {
+			log(fmt.Sprintf("Polling Envoy (%d), status: Not ready yet", pollCount))
+			return errors.New("not live yet")
+		}
+
+		return nil
+	}, readinessBackOff(ctx, cfg))
+}
+
+// drainEnvoy ... fails Envoy's health checks and gracefully drains its listeners, then waits
+// until there are no active connections or requests left or ENVOY_DRAIN_TIMEOUT is reached.
+// Returns true if Envoy drained before the deadline.
+func drainEnvoy(ctx context.Context, cfg ScuttleConfig) bool {
+	if cfg.EnvoyDrainTimeout <= time.Duration(0) || cfg.EnvoyAdminAPI == "" || !isLocalAPI(cfg.EnvoyAdminAPI) {
+		return true
+	}
+
+	log(fmt.Sprintf("Draining Envoy for up to %s", cfg.EnvoyDrainTimeout))
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, cfg.EnvoyDrainTimeout)
+	defer cancel()
+
+	for _, path := range []string{"/healthcheck/fail", "/drain_listeners?graceful"} {
+		url := fmt.Sprintf("%s%s", cfg.EnvoyAdminAPI, path)
+		resp := sendShutdownRequest(ctx, typhon.NewRequest(ctx, "POST", url, nil), cfg.ShutdownCallTimeout)
+		if resp.Error != nil {
+			log(fmt.Sprintf("Sent POST to '%s', error: %s", url, resp.Error))
+			continue
+		}
+		log(fmt.Sprintf("Sent POST to '%s', status code: %d", url, resp.StatusCode))
+	}
+
+	var connections, requests int64
+	b := backoff.WithContext(backoff.NewConstantBackOff(drainPollInterval), ctx)
+	err := backoff.Retry(func() error {
+		var err error
+		connections, requests, err = getEnvoyActivity(ctx, cfg.EnvoyAdminAPI)
+		if err != nil {
+			log(fmt.Sprintf("Polling Envoy stats, error: %s", err))
+			return err
+		}
+		if connections > 0 || requests > 0 {
+			return errors.New("envoy not drained yet")
+		}
+		return nil
+	}, b)
+
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		log(fmt.Sprintf("Envoy drain deadline of %s reached after %s, %d connections and %d requests still active", cfg.EnvoyDrainTimeout, elapsed, connections, requests))
+		return false
+	}
+	log(fmt.Sprintf("Envoy drained after %s", elapsed))
+	return true
+}
+
+// getEnvoyActivity ... sums the active downstream connections and upstream requests from Envoy's /stats,
+// excluding the admin listener scuttle itself is connected to and the control plane clusters
+func getEnvoyActivity(ctx context.Context, adminAPI string) (int64, int64, error) {
+	url := fmt.Sprintf("%s/stats", adminAPI)
+	rsp := typhon.NewRequest(ctx, "GET", url, nil).Send().Response()
+	if rsp.Error != nil {
+		return 0, 0, rsp.Error
+	}
+	body, err := rsp.BodyBytes(true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var connections, requests int64
+	for _, line := range strings.Split(string(body), "\n") {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := parts[0]
+		if strings.HasPrefix(name, "http.admin.") || strings.HasPrefix(name, "listener.admin.") || isControlPlaneStat(name) {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".downstream_cx_active"):
+			connections += value
+		case strings.HasSuffix(name, ".upstream_rq_active"):
+			requests += value
+		}
+	}
+	return connections, requests, nil
+}
+
+// isControlPlaneStat ... whether the stat belongs to one of controlPlaneClusters
+func isControlPlaneStat(name string) bool {
+	for _, cluster := range controlPlaneClusters {
+		if strings.HasPrefix(name, "cluster."+cluster+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// sendQuitQuitQuit ... POSTs to the /quitquitquit endpoint of the given API, returns true on a 200 response
+func sendQuitQuitQuit(ctx context.Context, cfg ScuttleConfig, target string, api string) bool {
+	url := fmt.Sprintf("%s/quitquitquit", api)
+	resp := sendShutdownRequest(ctx, typhon.NewRequest(ctx, "POST", url, nil), cfg.ShutdownCallTimeout)
+
+	if resp.Error != nil {
+		log(fmt.Sprintf("Sent quitquitquit to %s, error: %s", target, resp.Error))
+		return false
+	}
+
+	log(fmt.Sprintf("Sent quitquitquit to %s, status code: %d", target, resp.StatusCode))
+	return resp.StatusCode == 200
+}
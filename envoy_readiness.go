@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenk/backoff"
+	"github.com/monzo/typhon"
+)
+
+// envoyClusters ... represents the response from Envoy's /clusters?format=json endpoint
+type envoyClusters struct {
+	ClusterStatuses []struct {
+		Name         string `json:"name"`
+		HostStatuses []struct {
+			HealthStatus envoyHostHealth `json:"health_status"`
+		} `json:"host_statuses"`
+	} `json:"cluster_statuses"`
+}
+
+// envoyListeners ... represents the response from Envoy's /listeners?format=json endpoint
+type envoyListeners struct {
+	ListenerStatuses []struct {
+		Name         string `json:"name"`
+		LocalAddress struct {
+			SocketAddress struct {
+				Address   string `json:"address"`
+				PortValue int    `json:"port_value"`
+			} `json:"socket_address"`
+		} `json:"local_address"`
+	} `json:"listener_statuses"`
+}
+
+// envoyStats ... represents the response from Envoy's /stats?format=json endpoint
+type envoyStats struct {
+	Stats []struct {
+		Name  string      `json:"name"`
+		Value interface{} `json:"value"`
+	} `json:"stats"`
+}
+
+// Stats that show Envoy has received its mesh config, and whether it is connected to the control plane
+const (
+	cdsUpdateSuccessStat  = "cluster_manager.cds.update_success"
+	ldsUpdateSuccessStat  = "listener_manager.lds.update_success"
+	controlPlaneStateStat = "control_plane.connected_state"
+	envoyXDSStatsFilter   = "^(cluster_manager\\.cds\\.update_success|listener_manager\\.lds\\.update_success|control_plane\\.connected_state)$"
+)
+
+// envoyHostHealth ... the health flags Envoy reports for each host of a cluster
+type envoyHostHealth struct {
+	EdsHealthStatus            string `json:"eds_health_status"`
+	FailedActiveHealthCheck    bool   `json:"failed_active_health_check"`
+	FailedOutlierCheck         bool   `json:"failed_outlier_check"`
+	FailedActiveDegradedCheck  bool   `json:"failed_active_degraded_check"`
+	PendingDynamicRemoval      bool   `json:"pending_dynamic_removal"`
+	PendingActiveHC            bool   `json:"pending_active_hc"`
+	ExcludedViaImmediateHCFail bool   `json:"excluded_via_immediate_hc_fail"`
+}
+
+func (h envoyHostHealth) healthy() bool {
+	if h.EdsHealthStatus != "" && h.EdsHealthStatus != "HEALTHY" {
+		return false
+	}
+	return !h.FailedActiveHealthCheck && !h.FailedOutlierCheck && !h.FailedActiveDegradedCheck &&
+		!h.PendingDynamicRemoval && !h.PendingActiveHC && !h.ExcludedViaImmediateHCFail
+}
+
+// waitForEnvoyReady ... waits for Envoy to be LIVE, then for its xDS config to be applied when ENVOY_READY_XDS
+// is set, for ENVOY_READY_CLUSTERS to have healthy hosts and for ENVOY_READY_LISTENERS to be bound
+func waitForEnvoyReady(ctx context.Context, cfg ScuttleConfig) error {
+	if err := pollEnvoy(ctx, cfg); err != nil {
+		return err
+	}
+	if err := pollEnvoyXDS(ctx, cfg); err != nil {
+		return err
+	}
+	if err := pollEnvoyClusters(ctx, cfg); err != nil {
+		return err
+	}
+	return pollEnvoyListeners(ctx, cfg)
+}
+
+// pollEnvoyAdmin ... polls an Envoy admin endpoint with backoff until check reports nothing left to wait for
+func pollEnvoyAdmin(ctx context.Context, cfg ScuttleConfig, what string, path string, check func(rsp typhon.Response) ([]string, error)) error {
+	url := fmt.Sprintf("%s%s", cfg.EnvoyAdminAPI, path)
+	pollCount := 0
+
+	return backoff.Retry(func() error {
+		pollCount++
+		rsp := typhon.NewRequest(ctx, "GET", url, nil).Send().Response()
+
+		waiting, err := check(rsp)
+		if err != nil {
+			log(fmt.Sprintf("Polling Envoy %s (%d), error: %s", what, pollCount, err))
+			return err
+		}
+
+		if len(waiting) > 0 {
+			log(fmt.Sprintf("Polling Envoy %s (%d), waiting for: %s", what, pollCount, strings.Join(waiting, ", ")))
+			return fmt.Errorf("%s not ready yet", what)
+		}
+
+		return nil
+	}, readinessBackOff(ctx, cfg))
+}
+
+// pollEnvoyClusters ... polls Envoy's /clusters until each of ENVOY_READY_CLUSTERS has at least
+// ENVOY_READY_CLUSTERS_MIN_HEALTHY healthy hosts. When ENVOY_READY_CLUSTERS_TIMEOUT is reached
+// scuttle stops waiting on the clusters and carries on.
+func pollEnvoyClusters(ctx context.Context, cfg ScuttleConfig) error {
+	if len(cfg.EnvoyReadyClusters) == 0 {
+		return nil
+	}
+
+	phaseCtx := ctx
+	if cfg.EnvoyClustersTimeout > time.Duration(0) {
+		var cancel context.CancelFunc
+		phaseCtx, cancel = context.WithTimeout(ctx, cfg.EnvoyClustersTimeout)
+		defer cancel()
+	}
+
+	err := pollEnvoyAdmin(phaseCtx, cfg, "clusters", "/clusters?format=json", func(rsp typhon.Response) ([]string, error) {
+		clusters := &envoyClusters{}
+		if err := rsp.Decode(clusters); err != nil {
+			return nil, err
+		}
+		return unhealthyClusters(clusters, cfg.EnvoyReadyClusters, cfg.EnvoyClustersMinHealthy), nil
+	})
+
+	if err != nil && cfg.EnvoyClustersTimeout > time.Duration(0) {
+		// The backoff gives up just before a deadline, find out which one it was
+		<-phaseCtx.Done()
+		if ctx.Err() == nil {
+			log("ENVOY_READY_CLUSTERS_TIMEOUT reached, continuing without healthy clusters")
+			return nil
+		}
+	}
+	return err
+}
+
+// unhealthyClusters ... the names of the wanted clusters with less than minHealthy healthy hosts,
+// along with how many healthy hosts they have
+func unhealthyClusters(clusters *envoyClusters, wanted []string, minHealthy int) []string {
+	healthy := make(map[string]int, len(clusters.ClusterStatuses))
+	for _, cluster := range clusters.ClusterStatuses {
+		for _, host := range cluster.HostStatuses {
+			if host.HealthStatus.healthy() {
+				healthy[cluster.Name]++
+			}
+		}
+	}
+
+	waiting := make([]string, 0)
+	for _, name := range wanted {
+		name = strings.Trim(name, " ")
+		if healthy[name] < minHealthy {
+			waiting = append(waiting, fmt.Sprintf("%s (%d/%d healthy)", name, healthy[name], minHealthy))
+		}
+	}
+	return waiting
+}
+
+// pollEnvoyListeners ... polls Envoy's /listeners until each of ENVOY_READY_LISTENERS is bound
+func pollEnvoyListeners(ctx context.Context, cfg ScuttleConfig) error {
+	if len(cfg.EnvoyReadyListeners) == 0 {
+		return nil
+	}
+
+	return pollEnvoyAdmin(ctx, cfg, "listeners", "/listeners?format=json", func(rsp typhon.Response) ([]string, error) {
+		listeners := &envoyListeners{}
+		if err := rsp.Decode(listeners); err != nil {
+			return nil, err
+		}
+		return missingListeners(listeners, cfg.EnvoyReadyListeners), nil
+	})
+}
+
+// missingListeners ... the wanted listeners Envoy does not have, each is matched on
+// the listener's name or, when it is a number, on the port it is bound to
+func missingListeners(listeners *envoyListeners, wanted []string) []string {
+	missing := make([]string, 0)
+	for _, name := range wanted {
+		name = strings.Trim(name, " ")
+		port, err := strconv.Atoi(name)
+		found := false
+		for _, listener := range listeners.ListenerStatuses {
+			if listener.Name == name || (err == nil && listener.LocalAddress.SocketAddress.PortValue == port) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// pollEnvoyXDS ... polls Envoy's /stats until CDS and LDS have each applied at least one update, and
+// with ENVOY_READY_CONTROL_PLANE until Envoy is connected to the control plane
+func pollEnvoyXDS(ctx context.Context, cfg ScuttleConfig) error {
+	if !cfg.EnvoyReadyXDS {
+		return nil
+	}
+
+	path := "/stats?format=json&filter=" + url.QueryEscape(envoyXDSStatsFilter)
+	return pollEnvoyAdmin(ctx, cfg, "xDS", path, func(rsp typhon.Response) ([]string, error) {
+		stats := &envoyStats{}
+		if err := rsp.Decode(stats); err != nil {
+			return nil, err
+		}
+		return pendingXDS(stats, cfg.EnvoyReadyControlPlane), nil
+	})
+}
+
+// pendingXDS ... the xDS stats that do not show a synced Envoy yet
+func pendingXDS(stats *envoyStats, wantControlPlane bool) []string {
+	values := make(map[string]float64, len(stats.Stats))
+	for _, stat := range stats.Stats {
+		if value, ok := stat.Value.(float64); ok {
+			values[stat.Name] = value
+		}
+	}
+
+	pending := make([]string, 0)
+	if values[cdsUpdateSuccessStat] < 1 {
+		pending = append(pending, cdsUpdateSuccessStat)
+	}
+	if values[ldsUpdateSuccessStat] < 1 {
+		pending = append(pending, ldsUpdateSuccessStat)
+	}
+	if wantControlPlane && values[controlPlaneStateStat] != 1 {
+		pending = append(pending, controlPlaneStateStat)
+	}
+	return pending
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setEnvoyReadinessTestEnv(t *testing.T) {
+	t.Setenv("ENVOY_ADMIN_API", envoyAdminServer.URL)
+	t.Setenv("LINKERD_ADMIN_API", "")
+	t.Setenv("SIDECAR_DRIVERS", "envoy")
+	t.Setenv("READY_ENDPOINTS", "")
+	t.Setenv("START_WITHOUT_ENVOY", "false")
+	t.Setenv("WAIT_FOR_ENVOY_TIMEOUT", "")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "")
+	t.Setenv("ENVOY_READY_CLUSTERS", "")
+	t.Setenv("ENVOY_READY_LISTENERS", "")
+	t.Setenv("ENVOY_READY_XDS", "")
+	t.Setenv("ENVOY_READY_CONTROL_PLANE", "")
+}
+
+// Tests healthy hosts are counted per cluster
+func TestUnhealthyClusters(t *testing.T) {
+	fmt.Println("Starting TestUnhealthyClusters")
+	clusters := &envoyClusters{}
+	if err := json.Unmarshal([]byte(envoyClustersJSON), clusters); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		wanted     []string
+		minHealthy int
+		waiting    int
+	}{
+		{[]string{"outbound|5432||db.prod.svc.cluster.local"}, 1, 0},
+		{[]string{"outbound|5432||db.prod.svc.cluster.local"}, 2, 1},
+		{[]string{"outbound|6379||cache.prod.svc.cluster.local"}, 1, 1},
+		{[]string{"idontexist", " outbound|5432||db.prod.svc.cluster.local"}, 1, 1},
+	}
+	for _, test := range tests {
+		if waiting := unhealthyClusters(clusters, test.wanted, test.minHealthy); len(waiting) != test.waiting {
+			t.Errorf("%v with %d healthy hosts: expected %d clusters waiting, got %v", test.wanted, test.minHealthy, test.waiting, waiting)
+		}
+	}
+}
+
+// Tests scuttle waits for ENVOY_READY_CLUSTERS to have healthy hosts
+func TestEnvoyReadyClusters(t *testing.T) {
+	fmt.Println("Starting TestEnvoyReadyClusters")
+	initTestingEnv()
+	setEnvoyReadinessTestEnv(t)
+	t.Setenv("ENVOY_READY_CLUSTERS", "outbound|5432||db.prod.svc.cluster.local")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "300ms")
+	config = getConfig()
+	if err := waitForReadiness(t, 1*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cluster to be healthy, got: %s", err)
+	}
+
+	t.Setenv("ENVOY_READY_CLUSTERS", "outbound|5432||db.prod.svc.cluster.local,outbound|6379||cache.prod.svc.cluster.local")
+	config = getConfig()
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with an unhealthy cluster, got: %s", err)
+	}
+}
+
+// Tests ENVOY_READY_CLUSTERS_TIMEOUT stops waiting on clusters without failing readiness
+func TestEnvoyReadyClustersTimeout(t *testing.T) {
+	fmt.Println("Starting TestEnvoyReadyClustersTimeout")
+	initTestingEnv()
+	setEnvoyReadinessTestEnv(t)
+	t.Setenv("ENVOY_READY_CLUSTERS", "outbound|5432||db.prod.svc.cluster.local")
+	t.Setenv("ENVOY_READY_CLUSTERS_MIN_HEALTHY", "2")
+	t.Setenv("ENVOY_READY_CLUSTERS_TIMEOUT", "300ms")
+	config = getConfig()
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected scuttle to continue after the cluster timeout, got: %s", err)
+	}
+}
+
+// Tests listeners are matched by name or port
+func TestMissingListeners(t *testing.T) {
+	fmt.Println("Starting TestMissingListeners")
+	listeners := &envoyListeners{}
+	if err := json.Unmarshal([]byte(envoyListenersJSON), listeners); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		wanted  []string
+		missing []string
+	}{
+		{[]string{"virtualOutbound", "0.0.0.0_8080"}, []string{}},
+		{[]string{"15001", " 8080"}, []string{}},
+		{[]string{"virtualInbound", "9090", "8080"}, []string{"virtualInbound", "9090"}},
+	}
+	for _, test := range tests {
+		if missing := missingListeners(listeners, test.wanted); !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("%v: expected %v missing, got %v", test.wanted, test.missing, missing)
+		}
+	}
+}
+
+// Tests scuttle waits for ENVOY_READY_LISTENERS to be bound
+func TestEnvoyReadyListeners(t *testing.T) {
+	fmt.Println("Starting TestEnvoyReadyListeners")
+	initTestingEnv()
+	setEnvoyReadinessTestEnv(t)
+	t.Setenv("ENVOY_READY_LISTENERS", "virtualOutbound,8080")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "300ms")
+	config = getConfig()
+	if err := waitForReadiness(t, 1*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the listeners to be bound, got: %s", err)
+	}
+
+	t.Setenv("ENVOY_READY_LISTENERS", "virtualOutbound,9090")
+	config = getConfig()
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with a missing listener, got: %s", err)
+	}
+}
+
+// Tests the xDS stats that still need to sync are reported
+func TestPendingXDS(t *testing.T) {
+	fmt.Println("Starting TestPendingXDS")
+	synced := &envoyStats{}
+	if err := json.Unmarshal([]byte(envoyXDSStatsJSON), synced); err != nil {
+		t.Fatal(err)
+	}
+	if pending := pendingXDS(synced, false); len(pending) != 0 {
+		t.Errorf("Expected CDS and LDS to be synced, got %v pending", pending)
+	}
+	if pending := pendingXDS(synced, true); !reflect.DeepEqual(pending, []string{controlPlaneStateStat}) {
+		t.Errorf("Expected the control plane to be pending, got %v", pending)
+	}
+	if pending := pendingXDS(&envoyStats{}, false); !reflect.DeepEqual(pending, []string{cdsUpdateSuccessStat, ldsUpdateSuccessStat}) {
+		t.Errorf("Expected CDS and LDS to be pending without stats, got %v", pending)
+	}
+}
+
+// Tests scuttle waits for ENVOY_READY_XDS and ENVOY_READY_CONTROL_PLANE
+func TestEnvoyReadyXDS(t *testing.T) {
+	fmt.Println("Starting TestEnvoyReadyXDS")
+	initTestingEnv()
+	setEnvoyReadinessTestEnv(t)
+	t.Setenv("ENVOY_READY_XDS", "true")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "300ms")
+	config = getConfig()
+	if err := waitForReadiness(t, 1*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected xDS to be synced, got: %s", err)
+	}
+
+	t.Setenv("ENVOY_READY_CONTROL_PLANE", "true")
+	config = getConfig()
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with the control plane disconnected, got: %s", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// What an EXIT_CODE_POLICY rule does with the sidecars
+const (
+	// policyKeep ... leaves the sidecars running, for example to debug the pod
+	policyKeep = "keep"
+	// policyKill ... stops the sidecars, even with NEVER_KILL_ISTIO or NEVER_KILL_ISTIO_ON_FAILURE set
+	policyKill = "kill"
+)
+
+// ExitCodeRule ... one rule of EXIT_CODE_POLICY, such as `137,2=keep` or `3=kill:0`
+type ExitCodeRule struct {
+	Spec string
+	// Codes ... inclusive ranges of exit codes the rule matches, a single code is a range of one
+	Codes [][2]int
+	// Signals ... the rule also matches a child process terminated by one of these signals
+	Signals []syscall.Signal
+	// Any ... the rule matches every exit
+	Any    bool
+	Action string
+	// RewriteExitCode ... scuttle exits with ExitCode instead of the child's exit code
+	RewriteExitCode bool
+	ExitCode        int
+}
+
+// parseExitCodePolicy ... parses the rules of EXIT_CODE_POLICY, separated by semicolons.
+// Each rule is `<matches>=<action>[:<exit code>]`, where matches is a CSV of exit codes,
+// ranges like `1-125`, signals like `SIGKILL` or `*`, and action is keep or kill.
+func parseExitCodePolicy(policy string) ([]ExitCodeRule, error) {
+	rules := make([]ExitCodeRule, 0)
+	for i, spec := range strings.Split(policy, ";") {
+		spec = strings.Trim(spec, " ")
+		rule, err := parseExitCodeRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d '%s': %w", i+1, spec, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseExitCodeRule(spec string) (ExitCodeRule, error) {
+	rule := ExitCodeRule{Spec: spec}
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return rule, errors.New("expected <matches>=<action>")
+	}
+
+	if err := parseExitCodeMatches(&rule, parts[0]); err != nil {
+		return rule, err
+	}
+
+	action := strings.SplitN(strings.Trim(parts[1], " "), ":", 2)
+	rule.Action = strings.Trim(action[0], " ")
+	if rule.Action != policyKeep && rule.Action != policyKill {
+		return rule, fmt.Errorf("unknown action '%s'", rule.Action)
+	}
+	if len(action) == 2 {
+		code, err := parseExitCode(action[1])
+		if err != nil {
+			return rule, fmt.Errorf("invalid exit code '%s'", action[1])
+		}
+		rule.RewriteExitCode = true
+		rule.ExitCode = code
+	}
+	return rule, nil
+}
+
+// parseExitCodeMatches ... adds the CSV of exit codes, ranges, signals and `*` in matches to rule
+func parseExitCodeMatches(rule *ExitCodeRule, matches string) error {
+	for _, match := range strings.Split(matches, ",") {
+		match = strings.Trim(match, " ")
+		if match == "*" {
+			rule.Any = true
+			continue
+		}
+		if bounds := strings.SplitN(match, "-", 2); len(bounds) == 2 && bounds[0] != "" {
+			low, lowErr := parseExitCode(bounds[0])
+			high, highErr := parseExitCode(bounds[1])
+			if lowErr != nil || highErr != nil || low > high {
+				return fmt.Errorf("invalid range '%s'", match)
+			}
+			rule.Codes = append(rule.Codes, [2]int{low, high})
+			continue
+		}
+		if code, err := parseExitCode(match); err == nil {
+			rule.Codes = append(rule.Codes, [2]int{code, code})
+			continue
+		}
+		if !strings.HasPrefix(strings.ToUpper(match), "SIG") {
+			return fmt.Errorf("invalid exit code or signal '%s'", match)
+		}
+		sig, err := parseSignal(match)
+		if err != nil {
+			return err
+		}
+		rule.Signals = append(rule.Signals, sig)
+	}
+	return nil
+}
+
+// parseExitCode ... an exit code between 0 and 255
+func parseExitCode(value string) (int, error) {
+	code, err := strconv.Atoi(strings.Trim(value, " "))
+	if err != nil || code < 0 || code > 255 {
+		return 0, fmt.Errorf("invalid exit code '%s'", value)
+	}
+	return code, nil
+}
+
+func (r ExitCodeRule) matches(exitCode int, sig syscall.Signal) bool {
+	if r.Any {
+		return true
+	}
+	for _, codes := range r.Codes {
+		if exitCode >= codes[0] && exitCode <= codes[1] {
+			return true
+		}
+	}
+	for _, ruleSig := range r.Signals {
+		if sig != 0 && sig == ruleSig {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExitCodePolicy ... the first rule matching the child's exit code or terminating signal, if any
+func matchExitCodePolicy(rules []ExitCodeRule, exitCode int, sig syscall.Signal) (ExitCodeRule, bool) {
+	for _, rule := range rules {
+		if rule.matches(exitCode, sig) {
+			return rule, true
+		}
+	}
+	return ExitCodeRule{}, false
+}
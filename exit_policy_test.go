@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// Tests EXIT_CODE_POLICY rules are parsed, and invalid rules rejected
+func TestParseExitCodePolicy(t *testing.T) {
+	fmt.Println("Starting TestParseExitCodePolicy")
+	rules, err := parseExitCodePolicy("137, 2, SIGKILL=keep; 64-78=kill:1; *=kill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ExitCodeRule{
+		{Spec: "137, 2, SIGKILL=keep", Codes: [][2]int{{137, 137}, {2, 2}}, Signals: []syscall.Signal{syscall.SIGKILL}, Action: policyKeep},
+		{Spec: "64-78=kill:1", Codes: [][2]int{{64, 78}}, Action: policyKill, RewriteExitCode: true, ExitCode: 1},
+		{Spec: "*=kill", Any: true, Action: policyKill},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, rules)
+	}
+
+	invalid := []string{"", "137", "137=restart", "abc=keep", "-1=keep", "256=keep", "78-64=keep", "SIGNOPE=keep", "3=kill:zero", "3=kill:300", "0=kill;"}
+	for _, policy := range invalid {
+		if _, err := parseExitCodePolicy(policy); err == nil {
+			t.Errorf("%s: expected the policy to be rejected", policy)
+		}
+	}
+}
+
+// Tests the first matching rule decides what happens to the sidecars and scuttle's exit code
+func TestMatchExitCodePolicy(t *testing.T) {
+	fmt.Println("Starting TestMatchExitCodePolicy")
+	rules, err := parseExitCodePolicy("137,2,SIGSEGV=keep; 0,1=kill; 3=kill:0; 64-78=keep:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		exitCode int
+		sig      syscall.Signal
+		matched  bool
+		action   string
+		final    int
+	}{
+		{0, 0, true, policyKill, 0},
+		{1, 0, true, policyKill, 1},
+		{2, 0, true, policyKeep, 2},
+		{3, 0, true, policyKill, 0},
+		{4, 0, false, "", 4},
+		{64, 0, true, policyKeep, 1},
+		{78, 0, true, policyKeep, 1},
+		{79, 0, false, "", 79},
+		{137, 0, true, policyKeep, 137},
+		{-1, syscall.SIGSEGV, true, policyKeep, -1},
+		{-1, syscall.SIGTERM, false, "", -1},
+	}
+	for _, test := range tests {
+		rule, ok := matchExitCodePolicy(rules, test.exitCode, test.sig)
+		final := test.exitCode
+		if rule.RewriteExitCode {
+			final = rule.ExitCode
+		}
+		if ok != test.matched || rule.Action != test.action || final != test.final {
+			t.Errorf("exit code %d, signal %d: expected (%v, '%s', %d), got (%v, '%s', %d)", test.exitCode, test.sig, test.matched, test.action, test.final, ok, rule.Action, final)
+		}
+	}
+}
+
+// Tests kill() follows the matched rule over NEVER_KILL_ISTIO_ON_FAILURE, and returns the rewritten exit code
+func TestKillWithExitCodePolicy(t *testing.T) {
+	fmt.Println("Starting TestKillWithExitCodePolicy")
+	rules, _ := parseExitCodePolicy("137=keep; 3=kill:0; 4=kill")
+	tests := []struct {
+		exitCode int
+		stopped  bool
+		final    int
+	}{
+		{137, false, 137},
+		{3, true, 0},
+		{4, true, 4},
+	}
+	for _, test := range tests {
+		config = ScuttleConfig{LoggingEnabled: true, SidecarDrivers: []string{"fake"}, NeverKillIstio: true, NeverKillIstioOnFailure: true, ExitCodePolicy: rules}
+		if final := kill(test.exitCode, 0); final != test.final {
+			t.Errorf("exit code %d: expected scuttle exit code %d, got %d", test.exitCode, test.final, final)
+		}
+		if stopped := len(lastFakeDriver.shutdown) > 0; stopped != test.stopped {
+			t.Errorf("exit code %d: expected sidecars stopped to be %v", test.exitCode, test.stopped)
+		}
+	}
+}
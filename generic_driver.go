@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenk/backoff"
+	"github.com/monzo/typhon"
+)
+
+func init() {
+	registerDriver("generic", newGenericDriver)
+}
+
+// QuitEndpoint ... one of GENERIC_QUIT_ENDPOINTS, given as a URL or as a JSON object
+type QuitEndpoint struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	// Body ... sent as is when it is a JSON string, otherwise as JSON
+	Body json.RawMessage `json:"body"`
+	// ExpectedStatus ... the status codes that count as success, any 2xx when empty
+	ExpectedStatus []int `json:"expectedStatus"`
+	// Timeout ... limits each call, given as a duration string in JSON
+	Timeout time.Duration `json:"-"`
+	// Retries ... how many times a failed call is retried with backoff
+	Retries int `json:"retries"`
+	// AnyStatus ... endpoints given as a plain URL only log the response, a 200 is treated the same as a 404 or 500
+	AnyStatus bool `json:"-"`
+}
+
+// UnmarshalJSON ... reads the timeout as a duration string, such as "5s"
+func (e *QuitEndpoint) UnmarshalJSON(data []byte) error {
+	type plainEndpoint QuitEndpoint
+	endpoint := struct {
+		*plainEndpoint
+		Timeout string `json:"timeout"`
+	}{plainEndpoint: (*plainEndpoint)(e)}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&endpoint); err != nil {
+		return err
+	}
+	if endpoint.Timeout != "" {
+		timeout, err := time.ParseDuration(endpoint.Timeout)
+		if err != nil || timeout <= time.Duration(0) {
+			return fmt.Errorf("invalid timeout '%s'", endpoint.Timeout)
+		}
+		e.Timeout = timeout
+	}
+	return nil
+}
+
+func (e QuitEndpoint) String() string {
+	return fmt.Sprintf("%s %s", e.method(), e.URL)
+}
+
+func (e QuitEndpoint) method() string {
+	if e.Method == "" {
+		return "POST"
+	}
+	return strings.ToUpper(e.Method)
+}
+
+// bodyBytes ... the body to send, and whether it is JSON
+func (e QuitEndpoint) bodyBytes() ([]byte, bool) {
+	if len(e.Body) == 0 {
+		return nil, false
+	}
+	var text string
+	if err := json.Unmarshal(e.Body, &text); err == nil {
+		return []byte(text), false
+	}
+	return e.Body, true
+}
+
+func (e QuitEndpoint) expects(statusCode int) bool {
+	if e.AnyStatus {
+		return true
+	}
+	if len(e.ExpectedStatus) == 0 {
+		return statusCode >= 200 && statusCode <= 299
+	}
+	for _, expected := range e.ExpectedStatus {
+		if statusCode == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// getQuitEndpointsFromEnv ... reads GENERIC_QUIT_ENDPOINTS, either a CSV of URLs or a JSON array of endpoints,
+// followed by the JSON array in the GENERIC_QUIT_ENDPOINTS_FILE file. Only the JSON endpoints are validated.
+func getQuitEndpointsFromEnv(name string, fileName string, logEnabled bool) ([]QuitEndpoint, error) {
+	endpoints := make([]QuitEndpoint, 0)
+	if userVal := strings.Trim(getStringFromEnv(name, "", false), " "); strings.HasPrefix(userVal, "[") {
+		if logEnabled {
+			log(fmt.Sprintf("%s: %s", name, userVal))
+		}
+		parsed, err := parseQuitEndpoints([]byte(userVal))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		endpoints = append(endpoints, parsed...)
+	} else {
+		for _, endpoint := range getStringArrayFromEnv(name, make([]string, 0), logEnabled) {
+			endpoints = append(endpoints, QuitEndpoint{URL: strings.Trim(endpoint, " "), AnyStatus: true})
+		}
+	}
+
+	if file := getStringFromEnv(fileName, "", false); file != "" {
+		if logEnabled {
+			log(fmt.Sprintf("%s: %s", fileName, file))
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fileName, err)
+		}
+		parsed, err := parseQuitEndpoints(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fileName, err)
+		}
+		endpoints = append(endpoints, parsed...)
+	}
+	return endpoints, nil
+}
+
+// parseQuitEndpoints ... parses and validates a JSON array of endpoints
+func parseQuitEndpoints(data []byte) ([]QuitEndpoint, error) {
+	var endpoints []QuitEndpoint
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+	for i, endpoint := range endpoints {
+		if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("endpoint %d: invalid URL '%s'", i+1, endpoint.URL)
+		}
+		if endpoint.Retries < 0 {
+			return nil, fmt.Errorf("endpoint %d: invalid retries %d", i+1, endpoint.Retries)
+		}
+		for _, statusCode := range endpoint.ExpectedStatus {
+			if statusCode < 100 || statusCode > 599 {
+				return nil, fmt.Errorf("endpoint %d: invalid expected status %d", i+1, statusCode)
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// genericDriver ... calls each of GENERIC_QUIT_ENDPOINTS on shutdown
+type genericDriver struct {
+	cfg ScuttleConfig
+}
+
+func newGenericDriver(cfg ScuttleConfig) SidecarDriver {
+	return &genericDriver{cfg: cfg}
+}
+
+func (d *genericDriver) Name() string {
+	return "generic"
+}
+
+func (d *genericDriver) WaitReady(ctx context.Context) error {
+	return errNoReadinessCheck
+}
+
+// Shutdown ... calls every endpoint in parallel, and reports the result of each
+func (d *genericDriver) Shutdown(ctx context.Context, exitCode int) error {
+	errs := make([]error, len(d.cfg.GenericQuitEndpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range d.cfg.GenericQuitEndpoints {
+		wg.Add(1)
+		go func(i int, endpoint QuitEndpoint) {
+			defer wg.Done()
+			errs[i] = callQuitEndpoint(ctx, d.cfg, endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	failed := make([]string, 0)
+	for i, endpoint := range d.cfg.GenericQuitEndpoints {
+		if errs[i] != nil {
+			log(fmt.Sprintf("Generic quit endpoint '%s' failed, error: %s", endpoint, errs[i]))
+			failed = append(failed, endpoint.String())
+			continue
+		}
+		log(fmt.Sprintf("Generic quit endpoint '%s' succeeded", endpoint))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("generic quit endpoints failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// callQuitEndpoint ... calls the endpoint until it returns an expected status code or its retries run out.
+// Each call is limited by the endpoint's timeout, or SHUTDOWN_CALL_TIMEOUT.
+func callQuitEndpoint(ctx context.Context, cfg ScuttleConfig, endpoint QuitEndpoint) error {
+	timeout := cfg.ShutdownCallTimeout
+	if endpoint.Timeout > time.Duration(0) {
+		timeout = endpoint.Timeout
+	}
+
+	attempt := 0
+	return backoff.Retry(func() error {
+		attempt++
+		req := typhon.NewRequest(ctx, endpoint.method(), endpoint.URL, nil)
+		if body, isJSON := endpoint.bodyBytes(); body != nil {
+			req.Write(body)
+			if isJSON && req.Header != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+		}
+		for key, value := range endpoint.Headers {
+			if req.Header != nil {
+				req.Header.Set(key, value)
+			}
+		}
+
+		resp := sendShutdownRequest(ctx, req, timeout)
+		if resp.Error != nil {
+			log(fmt.Sprintf("Sent %s to '%s' (attempt %d), error: %s", endpoint.method(), endpoint.URL, attempt, resp.Error))
+			return resp.Error
+		}
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
+		log(fmt.Sprintf("Sent %s to '%s' (attempt %d), status code: %d", endpoint.method(), endpoint.URL, attempt, resp.StatusCode))
+		if !endpoint.expects(resp.StatusCode) {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	}, retryBackOff(ctx, endpoint.Retries, retryInitialInterval))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests GENERIC_QUIT_ENDPOINTS is read as a CSV of URLs or as JSON, and from GENERIC_QUIT_ENDPOINTS_FILE
+func TestGetQuitEndpoints(t *testing.T) {
+	fmt.Println("Starting TestGetQuitEndpoints")
+	t.Setenv("GENERIC_QUIT_ENDPOINTS_FILE", "")
+	t.Setenv("GENERIC_QUIT_ENDPOINTS", "http://127.0.0.1:3500/v1.0/shutdown, http://127.0.0.1:8080/quit")
+	endpoints, err := getQuitEndpointsFromEnv("GENERIC_QUIT_ENDPOINTS", "GENERIC_QUIT_ENDPOINTS_FILE", false)
+	expected := []QuitEndpoint{
+		{URL: "http://127.0.0.1:3500/v1.0/shutdown", AnyStatus: true},
+		{URL: "http://127.0.0.1:8080/quit", AnyStatus: true},
+	}
+	if err != nil || !reflect.DeepEqual(endpoints, expected) {
+		t.Fatalf("Expected %+v, got %+v (%v)", expected, endpoints, err)
+	}
+
+	file, err := ioutil.TempFile("", "scuttle-quit-endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[{"url": "http://127.0.0.1:9000/stop", "retries": 1}]`)
+	file.Close()
+
+	t.Setenv("GENERIC_QUIT_ENDPOINTS", `[{"url": "http://127.0.0.1:8080/quit", "method": "put", "headers": {"Authorization": "Bearer token"}, "body": {"reason": "done"}, "expectedStatus": [200, 202], "timeout": "2s", "retries": 3}]`)
+	t.Setenv("GENERIC_QUIT_ENDPOINTS_FILE", file.Name())
+	endpoints, err = getQuitEndpointsFromEnv("GENERIC_QUIT_ENDPOINTS", "GENERIC_QUIT_ENDPOINTS_FILE", false)
+	expected = []QuitEndpoint{
+		{
+			URL:            "http://127.0.0.1:8080/quit",
+			Method:         "put",
+			Headers:        map[string]string{"Authorization": "Bearer token"},
+			Body:           []byte(`{"reason": "done"}`),
+			ExpectedStatus: []int{200, 202},
+			Timeout:        2 * time.Second,
+			Retries:        3,
+		},
+		{URL: "http://127.0.0.1:9000/stop", Retries: 1},
+	}
+	if err != nil || !reflect.DeepEqual(endpoints, expected) {
+		t.Fatalf("Expected %+v, got %+v (%v)", expected, endpoints, err)
+	}
+
+	invalid := []string{
+		`[{"url": "http://127.0.0.1:8080/quit"}`,
+		`[{"url": "127.0.0.1:8080/quit"}]`,
+		`[{"url": "http://127.0.0.1:8080/quit", "timeout": "soon"}]`,
+		`[{"url": "http://127.0.0.1:8080/quit", "retries": -1}]`,
+		`[{"url": "http://127.0.0.1:8080/quit", "expectedStatus": [42]}]`,
+		`[{"url": "http://127.0.0.1:8080/quit", "retry": 1}]`,
+	}
+	t.Setenv("GENERIC_QUIT_ENDPOINTS_FILE", "")
+	for _, endpoints := range invalid {
+		t.Setenv("GENERIC_QUIT_ENDPOINTS", endpoints)
+		if _, err := getQuitEndpointsFromEnv("GENERIC_QUIT_ENDPOINTS", "GENERIC_QUIT_ENDPOINTS_FILE", false); err == nil {
+			t.Errorf("%s: expected the endpoints to be rejected", endpoints)
+		}
+	}
+}
+
+// Tests endpoints are called with their method, headers and body, and retried until the expected status
+func TestGenericQuitEndpointSpec(t *testing.T) {
+	fmt.Println("Starting TestGenericQuitEndpointSpec")
+	defer func(interval time.Duration) { retryInitialInterval = interval }(retryInitialInterval)
+	retryInitialInterval = 10 * time.Millisecond
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "PUT" || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" || string(body) != `{"reason":"done"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	endpoint := QuitEndpoint{
+		URL:            server.URL,
+		Method:         "PUT",
+		Headers:        map[string]string{"Authorization": "Bearer token"},
+		Body:           []byte(`{"reason":"done"}`),
+		ExpectedStatus: []int{202},
+	}
+	if err := callQuitEndpoint(context.Background(), ScuttleConfig{}, endpoint); err == nil {
+		t.Fatal("Expected an error without retries")
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	endpoint.Retries = 2
+	if err := callQuitEndpoint(context.Background(), ScuttleConfig{}, endpoint); err != nil {
+		t.Fatalf("Expected the third call to succeed, got: %s", err)
+	}
+
+	endpoint.Method = "POST"
+	endpoint.Retries = 0
+	driver := newGenericDriver(ScuttleConfig{GenericQuitEndpoints: []QuitEndpoint{endpoint, {URL: server.URL, AnyStatus: true}}})
+	if err := driver.Shutdown(context.Background(), 0); err == nil {
+		t.Fatal("Expected the driver to report the failed endpoint")
+	}
+}
+
+// Tests a blackholed endpoint is abandoned after SHUTDOWN_CALL_TIMEOUT, without holding up the others
+func TestGenericQuitEndpointsDeadline(t *testing.T) {
+	fmt.Println("Starting TestGenericQuitEndpointsDeadline")
+	blackhole := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blackhole
+	}))
+	defer slow.Close()
+	defer close(blackhole)
+	var calls int32
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer fast.Close()
+
+	cfg := ScuttleConfig{
+		GenericQuitEndpoints: []QuitEndpoint{{URL: slow.URL}, {URL: slow.URL + "/other"}, {URL: fast.URL}},
+		ShutdownCallTimeout:  300 * time.Millisecond,
+	}
+	started := time.Now()
+	if err := newGenericDriver(cfg).Shutdown(context.Background(), 0); err == nil {
+		t.Fatal("Expected the blackholed endpoints to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 550*time.Millisecond {
+		t.Fatalf("Expected the endpoints to be called in parallel within the deadline, took %s", elapsed)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatal("The responsive endpoint was not called")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Signals that start the pod's termination grace period. Unlike terminationSignals, SIGHUP and SIGQUIT
+// are left out, SIGHUP is often a reload and does not mean the pod is stopping.
+var gracePeriodSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+
+var (
+	terminationOnce sync.Once
+	// terminationStart ... when the first termination signal was received, the start of the pod's termination grace period
+	terminationStart atomic.Value
+)
+
+// terminationStarted ... records the time of the first SIGTERM or SIGINT, true when sig is that signal
+func terminationStarted(sig os.Signal) bool {
+	first := false
+	for _, termination := range gracePeriodSignals {
+		if sig == termination {
+			terminationOnce.Do(func() {
+				terminationStart.Store(time.Now())
+				first = true
+			})
+		}
+	}
+	return first
+}
+
+// isTerminationSignal ... whether sig is one of the signals asking scuttle to terminate
+func isTerminationSignal(sig os.Signal) bool {
+	for _, termination := range terminationSignals {
+		if sig == termination {
+			return true
+		}
+	}
+	return false
+}
+
+// terminationTime ... when the first termination signal was received, zero if none was
+func terminationTime() time.Time {
+	start, _ := terminationStart.Load().(time.Time)
+	return start
+}
+
+// startChildGracePeriod ... SIGKILLs the child, or its process group with CHILD_PROCESS_GROUP,
+// if it has not stopped once grace is over. Waits for it indefinitely when grace is 0.
+func startChildGracePeriod(child *os.Process, childStopped <-chan struct{}, grace time.Duration) {
+	if grace <= time.Duration(0) {
+		return
+	}
+	log(fmt.Sprintf("Child process %d has %s to exit before it is killed", child.Pid, grace))
+	go func() {
+		select {
+		case <-childStopped:
+		case <-time.After(grace):
+			log(fmt.Sprintf("Child process %d still running after the grace period of %s, sending SIGKILL", child.Pid, grace))
+			signalChild(child, syscall.SIGKILL)
+		}
+	}()
+}
+
+// shutdownBudget ... SHUTDOWN_TIMEOUT, cut down to what is left of TERMINATION_GRACE_PERIOD when a termination
+// signal was received at start. False when the shutdown is not limited.
+func shutdownBudget(cfg ScuttleConfig, start time.Time) (time.Duration, bool) {
+	budget, limited := cfg.ShutdownTimeout, cfg.ShutdownTimeout > time.Duration(0)
+	if start.IsZero() || cfg.TerminationGracePeriod <= time.Duration(0) {
+		return budget, limited
+	}
+	left := cfg.TerminationGracePeriod - time.Since(start)
+	if left < time.Duration(0) {
+		left = time.Duration(0)
+	}
+	if !limited || left < budget {
+		return left, true
+	}
+	return budget, true
+}
+
+// getGracePeriodFromFile ... reads a grace period from a file, such as a downward API volume exposing
+// an annotation, either in seconds like terminationGracePeriodSeconds or as a duration
+func getGracePeriodFromFile(path string) (time.Duration, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not read '%s': %w", path, err)
+	}
+	value := strings.TrimSpace(string(contents))
+	grace, err := time.ParseDuration(value)
+	if seconds, convErr := strconv.Atoi(value); convErr == nil {
+		grace, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || grace <= time.Duration(0) {
+		return 0, fmt.Errorf("invalid grace period '%s' in '%s'", value, path)
+	}
+	return grace, nil
+}
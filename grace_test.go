@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Tests the child grace period is cut down to leave SHUTDOWN_TIMEOUT of the termination grace period to the sidecars
+func TestTerminationGracePeriod(t *testing.T) {
+	fmt.Println("Starting TestTerminationGracePeriod")
+	file, err := ioutil.TempFile("", "scuttle-grace-period")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("45\n")
+	file.Close()
+
+	t.Setenv("SHUTDOWN_TIMEOUT", "15s")
+	t.Setenv("TERMINATION_GRACE_PERIOD", "")
+	t.Setenv("TERMINATION_GRACE_PERIOD_FILE", file.Name())
+	tests := map[string]time.Duration{
+		"":    30 * time.Second,
+		"10s": 10 * time.Second,
+		"40s": 30 * time.Second,
+	}
+	for childGrace, expected := range tests {
+		t.Setenv("CHILD_GRACE_PERIOD", childGrace)
+		cfg := getConfig()
+		if len(cfg.InvalidSettings) > 0 || cfg.TerminationGracePeriod != 45*time.Second || cfg.ChildGracePeriod != expected {
+			t.Errorf("CHILD_GRACE_PERIOD '%s': expected %s of 45s, got %s of %s %v", childGrace, expected, cfg.ChildGracePeriod, cfg.TerminationGracePeriod, cfg.InvalidSettings)
+		}
+	}
+
+	t.Setenv("TERMINATION_GRACE_PERIOD_FILE", "")
+	t.Setenv("CHILD_GRACE_PERIOD", "")
+	t.Setenv("SHUTDOWN_TIMEOUT", "")
+	t.Setenv("TERMINATION_GRACE_PERIOD", "30s")
+	if cfg := getConfig(); len(cfg.InvalidSettings) > 0 || len(cfg.Warnings) != 1 || cfg.ShutdownTimeout != 10*time.Second || cfg.ChildGracePeriod != 20*time.Second {
+		t.Errorf("Expected a third of a grace period no longer than SHUTDOWN_TIMEOUT to be reserved, got %s and %s %v", cfg.ShutdownTimeout, cfg.ChildGracePeriod, cfg.InvalidSettings)
+	}
+	ioutil.WriteFile(file.Name(), []byte("soon"), 0644)
+	t.Setenv("TERMINATION_GRACE_PERIOD_FILE", file.Name())
+	if cfg := getConfig(); len(cfg.InvalidSettings) == 0 {
+		t.Error("Expected an invalid grace period file to be rejected")
+	}
+}
+
+// Tests the shutdown budget is what is left of the termination grace period, if that is shorter
+func TestShutdownBudgetWithinGracePeriod(t *testing.T) {
+	fmt.Println("Starting TestShutdownBudgetWithinGracePeriod")
+	cfg := ScuttleConfig{ShutdownTimeout: 10 * time.Second, TerminationGracePeriod: 30 * time.Second}
+	if budget, limited := shutdownBudget(cfg, time.Time{}); budget != 10*time.Second || !limited {
+		t.Errorf("Expected SHUTDOWN_TIMEOUT without a termination signal, got %s", budget)
+	}
+	if budget, _ := shutdownBudget(cfg, time.Now().Add(-5*time.Second)); budget != 10*time.Second {
+		t.Errorf("Expected SHUTDOWN_TIMEOUT while enough of the grace period is left, got %s", budget)
+	}
+	if budget, _ := shutdownBudget(cfg, time.Now().Add(-25*time.Second)); budget > 5*time.Second || budget < 4*time.Second {
+		t.Errorf("Expected the 5s left of the grace period, got %s", budget)
+	}
+	if budget, limited := shutdownBudget(cfg, time.Now().Add(-time.Minute)); budget != 0 || !limited {
+		t.Errorf("Expected no budget after the grace period, got %s", budget)
+	}
+	cfg.ShutdownTimeout = 0
+	if budget, limited := shutdownBudget(cfg, time.Now().Add(-25*time.Second)); budget > 5*time.Second || !limited {
+		t.Errorf("Expected the grace period to limit an unlimited SHUTDOWN_TIMEOUT, got %s (%v)", budget, limited)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func init() {
+	registerDriver("istio", newIstioDriver)
+}
+
+// istioDriver ... an Envoy sidecar managed by Istio's pilot-agent
+type istioDriver struct {
+	cfg ScuttleConfig
+}
+
+func newIstioDriver(cfg ScuttleConfig) SidecarDriver {
+	return &istioDriver{cfg: cfg}
+}
+
+func (d *istioDriver) Name() string {
+	return "istio"
+}
+
+func (d *istioDriver) WaitReady(ctx context.Context) error {
+	return waitForIstioReady(ctx, d.cfg)
+}
+
+// Shutdown ... stops the sidecar with the Istio API or pkill, falling back to SIDECAR_QUIT_FALLBACK
+// and to pkill when ISTIO_FALLBACK_PKILL is set
+func (d *istioDriver) Shutdown(ctx context.Context, exitCode int) error {
+	if err := requireLocalAPI("ENVOY_ADMIN_API", d.cfg.EnvoyAdminAPI); err != nil {
+		return err
+	}
+	drainEnvoy(ctx, d.cfg)
+
+	primary := QuitMethodIstioAPI
+	switch {
+	case d.cfg.SidecarQuitMethod == QuitMethodPkill:
+		log("Stopping Istio with pkill, SIDECAR_QUIT_METHOD is pkill")
+		primary = QuitMethodPkill
+	case d.cfg.IstioQuitAPI == "":
+		log("Stopping Istio with pkill, ISTIO_QUIT_API is not set")
+		primary = QuitMethodPkill
+	}
+
+	fallbacks := append([]string{}, d.cfg.SidecarQuitFallback...)
+	if d.cfg.IstioFallbackPkill {
+		fallbacks = append(fallbacks, QuitMethodPkill)
+	}
+	return stopSidecar(ctx, d.cfg, "Istio", envoyLiveURL(d.cfg), envoyQuitStrategies(d.cfg, primary, fallbacks))
+}
+
+// waitForIstioReady ... polls pilot-agent's /healthz/ready when ISTIO_READY_API is set, which also covers the
+// agent's certificates, followed by the Envoy admin API checks unless ISTIO_READY_ONLY is set
+func waitForIstioReady(ctx context.Context, cfg ScuttleConfig) error {
+	if cfg.IstioReadyAPI != "" {
+		url := fmt.Sprintf("%s/healthz/ready", cfg.IstioReadyAPI)
+		if err := pollHTTPReady(ctx, cfg, "Istio agent", url, isStatusOK); err != nil {
+			return err
+		}
+		if cfg.IstioReadyOnly || cfg.EnvoyAdminAPI == "" {
+			return nil
+		}
+	}
+	return waitForEnvoyReady(ctx, cfg)
+}
+
+// killIstioWithPkill ... signals PKILL_PROCESS_NAME (pilot-agent by default) directly, escalating
+// to SIGTERM and SIGKILL if it is still running after PKILL_ESCALATION_WAIT
+func killIstioWithPkill(ctx context.Context, cfg ScuttleConfig) error {
+	log(fmt.Sprintf("Stopping Istio by sending %s to %s (intended for Istio <v1.3)", signalName(cfg.PkillSignal), cfg.PkillProcessName))
+
+	err := stopProcesses(ctx, cfg.PkillProcessName, cfg.PkillSignal, cfg.PkillEscalationWait)
+	if err != nil {
+		log(fmt.Sprintf("%s could not be stopped, err: %s", cfg.PkillProcessName, err))
+	}
+	return err
+}
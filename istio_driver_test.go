@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func setIstioReadinessTestEnv(t *testing.T, envoyAPI string, istioReadyAPI string) {
+	t.Setenv("ENVOY_ADMIN_API", envoyAPI)
+	t.Setenv("ISTIO_READY_API", istioReadyAPI)
+	t.Setenv("ISTIO_READY_ONLY", "")
+	t.Setenv("LINKERD_ADMIN_API", "")
+	t.Setenv("SIDECAR_DRIVERS", "istio")
+	t.Setenv("READY_ENDPOINTS", "")
+	t.Setenv("START_WITHOUT_ENVOY", "false")
+	t.Setenv("WAIT_FOR_ENVOY_TIMEOUT", "")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "300ms")
+	config = getConfig()
+}
+
+// Tests scuttle waits for both pilot-agent's /healthz/ready and Envoy
+func TestIstioAgentReady(t *testing.T) {
+	fmt.Println("Starting TestIstioAgentReady")
+	initTestingEnv()
+	setIstioReadinessTestEnv(t, goodServer.URL, istioAgentServer.URL)
+	if err := waitForReadiness(t, 1*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the Istio agent and Envoy to be ready, got: %s", err)
+	}
+
+	setIstioReadinessTestEnv(t, goodServer.URL, badServer.URL)
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with the Istio agent returning 503, got: %s", err)
+	}
+
+	setIstioReadinessTestEnv(t, badServer.URL, istioAgentServer.URL)
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with Envoy not live, got: %s", err)
+	}
+}
+
+// Tests ISTIO_READY_ONLY skips the Envoy admin API checks
+func TestIstioAgentReadyOnly(t *testing.T) {
+	fmt.Println("Starting TestIstioAgentReadyOnly")
+	initTestingEnv()
+	setIstioReadinessTestEnv(t, badServer.URL, istioAgentServer.URL)
+	t.Setenv("ISTIO_READY_ONLY", "true")
+	config = getConfig()
+	if err := waitForReadiness(t, 1*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the Istio agent alone to be enough, got: %s", err)
+	}
+}
+
+// Tests the default envoy driver, chosen when ISTIO_QUIT_API is not set, still waits for pilot-agent
+func TestIstioAgentReadyWithEnvoyDriver(t *testing.T) {
+	fmt.Println("Starting TestIstioAgentReadyWithEnvoyDriver")
+	initTestingEnv()
+	setIstioReadinessTestEnv(t, goodServer.URL, badServer.URL)
+	t.Setenv("SIDECAR_DRIVERS", "")
+	t.Setenv("ISTIO_QUIT_API", "")
+	config = getConfig()
+	if names := driverNames(getDrivers(config)); names != "envoy" {
+		t.Fatalf("Expected the envoy driver, got '%s'", names)
+	}
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with the Istio agent returning 503, got: %s", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/monzo/typhon"
+)
+
+func init() {
+	registerDriver("linkerd", newLinkerdDriver)
+}
+
+// linkerdDriver ... a linkerd-proxy sidecar, using the proxy's admin server
+type linkerdDriver struct {
+	cfg ScuttleConfig
+}
+
+func newLinkerdDriver(cfg ScuttleConfig) SidecarDriver {
+	return &linkerdDriver{cfg: cfg}
+}
+
+func (d *linkerdDriver) Name() string {
+	return "linkerd"
+}
+
+// WaitReady ... polls the proxy's /ready endpoint with backoff until it returns a 200
+func (d *linkerdDriver) WaitReady(ctx context.Context) error {
+	url := fmt.Sprintf("%s/ready", d.cfg.LinkerdAdminAPI)
+	return pollHTTPReady(ctx, d.cfg, "Linkerd", url, isStatusOK)
+}
+
+// Shutdown ... POSTs to the proxy's /shutdown endpoint, verified against /ready unless QUIT_VERIFY_TIMEOUT is 0
+func (d *linkerdDriver) Shutdown(ctx context.Context, exitCode int) error {
+	if err := requireLocalAPI("LINKERD_ADMIN_API", d.cfg.LinkerdAdminAPI); err != nil {
+		return err
+	}
+	strategy := quitStrategy{name: "linkerd-api", quit: d.sendShutdown}
+	return stopSidecar(ctx, d.cfg, "Linkerd", fmt.Sprintf("%s/ready", d.cfg.LinkerdAdminAPI), []quitStrategy{strategy})
+}
+
+func (d *linkerdDriver) sendShutdown(ctx context.Context) error {
+	log(fmt.Sprintf("Stopping Linkerd using Linkerd admin API '%s'", d.cfg.LinkerdAdminAPI))
+
+	url := fmt.Sprintf("%s/shutdown", d.cfg.LinkerdAdminAPI)
+	resp := sendShutdownRequest(ctx, typhon.NewRequest(ctx, "POST", url, nil), d.cfg.ShutdownCallTimeout)
+	if resp.Error != nil {
+		log(fmt.Sprintf("Sent shutdown to Linkerd, error: %s", resp.Error))
+		return resp.Error
+	}
+
+	log(fmt.Sprintf("Sent shutdown to Linkerd, status code: %d", resp.StatusCode))
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("shutdown to Linkerd returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
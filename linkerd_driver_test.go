@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Mimics linkerd-proxy's admin server, /ready returns 503 until ready is set
+func newLinkerdServer(ready *int32, shutdowns *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ready" && atomic.LoadInt32(ready) == 1:
+			w.Write([]byte("ready\n"))
+		case r.URL.Path == "/ready":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/shutdown" && r.Method == "POST":
+			atomic.AddInt32(shutdowns, 1)
+			w.Write([]byte("shutdown\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func setLinkerdTestEnv(t *testing.T, linkerdAPI string) {
+	t.Setenv("SCUTTLE_LOGGING", "true")
+	t.Setenv("ENVOY_ADMIN_API", "")
+	t.Setenv("LINKERD_ADMIN_API", linkerdAPI)
+	t.Setenv("START_WITHOUT_ENVOY", "false")
+	t.Setenv("GENERIC_QUIT_ENDPOINTS", "")
+	t.Setenv("WAIT_FOR_ENVOY_TIMEOUT", "")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "")
+	t.Setenv("QUIT_VERIFY_TIMEOUT", "0s")
+	config = getConfig()
+}
+
+// Tests scuttle blocks on Linkerd's /ready endpoint
+func TestLinkerdReady(t *testing.T) {
+	fmt.Println("Starting TestLinkerdReady")
+	var ready, shutdowns int32 = 1, 0
+	server := newLinkerdServer(&ready, &shutdowns)
+	defer server.Close()
+	setLinkerdTestEnv(t, server.URL)
+
+	blockingCtx := waitForEnvoy(context.Background())
+	if blockingCtx == nil {
+		t.Fatal("Blocking context was nil")
+	}
+	<-blockingCtx.Done()
+	if !errors.Is(blockingCtx.Err(), context.Canceled) {
+		t.Fatalf("Context contains wrong error: %s", blockingCtx.Err())
+	}
+}
+
+// Tests QUIT_WITHOUT_ENVOY_TIMEOUT applies to Linkerd as well
+func TestLinkerdNotReadyTimeout(t *testing.T) {
+	fmt.Println("Starting TestLinkerdNotReadyTimeout")
+	var ready, shutdowns int32 = 0, 0
+	server := newLinkerdServer(&ready, &shutdowns)
+	defer server.Close()
+	setLinkerdTestEnv(t, server.URL)
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "500ms")
+	config = getConfig()
+
+	blockingCtx := waitForEnvoy(context.Background())
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatal("Context did not timeout")
+	case <-blockingCtx.Done():
+		if !errors.Is(blockingCtx.Err(), context.DeadlineExceeded) {
+			t.Fatalf("Context contains wrong error: %s", blockingCtx.Err())
+		}
+	}
+}
+
+// Tests kill() sends /shutdown to Linkerd, unless NEVER_KILL_ISTIO is set
+func TestLinkerdShutdown(t *testing.T) {
+	fmt.Println("Starting TestLinkerdShutdown")
+	var ready, shutdowns int32 = 1, 0
+	server := newLinkerdServer(&ready, &shutdowns)
+	defer server.Close()
+	setLinkerdTestEnv(t, server.URL)
+
+	kill(0, 0)
+	if atomic.LoadInt32(&shutdowns) != 1 {
+		t.Fatal("Linkerd did not receive shutdown")
+	}
+
+	t.Setenv("NEVER_KILL_ISTIO", "true")
+	config = getConfig()
+	kill(0, 0)
+	if atomic.LoadInt32(&shutdowns) != 1 {
+		t.Fatal("Linkerd received shutdown with NEVER_KILL_ISTIO set")
+	}
+}
+
+// Tests each driver checks its own admin API is local, a remote Envoy does not keep Linkerd running
+func TestLinkerdShutdownWithRemoteEnvoy(t *testing.T) {
+	fmt.Println("Starting TestLinkerdShutdownWithRemoteEnvoy")
+	var ready, shutdowns int32 = 1, 0
+	server := newLinkerdServer(&ready, &shutdowns)
+	defer server.Close()
+	setLinkerdTestEnv(t, server.URL)
+	t.Setenv("ENVOY_ADMIN_API", "http://envoy.example.com:15000")
+	t.Setenv("SIDECAR_DRIVERS", "envoy,linkerd")
+	config = getConfig()
+
+	if err := newEnvoyDriver(config).Shutdown(context.Background(), 0); err == nil {
+		t.Fatal("Envoy driver stopped a remote Envoy")
+	}
+	kill(0, 0)
+	if atomic.LoadInt32(&shutdowns) != 1 {
+		t.Fatal("Linkerd did not receive shutdown")
+	}
+}
@@ -9,18 +9,11 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
-	"time"
+	"sync"
 	"syscall"
-
-	"github.com/cenk/backoff"
-	"github.com/monzo/typhon"
+	"time"
 )
 
-// ServerInfo ... represents the response from Envoy's server info endpoint
-type ServerInfo struct {
-	State string `json:"state"`
-}
-
 // Version ... Version of the binary, set to value like v1.0.0 in CI using ldflags
 var Version = "vlocal"
 
@@ -28,12 +21,60 @@ var (
 	config ScuttleConfig
 )
 
+// Exit codes used by scuttle itself, rather than passed on from the child process
+const (
+	// exitCodeEnvoyTimeout ... QUIT_WITHOUT_ENVOY_TIMEOUT was reached before the sidecars were ready
+	exitCodeEnvoyTimeout = 1
+	// exitCodeInvalidConfig ... a setting that must be valid was rejected when the config was loaded
+	exitCodeInvalidConfig = 122
+	// exitCodeInterrupted ... a termination signal was received before the child process started
+	exitCodeInterrupted = 123
+	// exitCodeMaxRuntime ... the child processes were stopped once MAX_RUNTIME was reached, like timeout(1)
+	exitCodeMaxRuntime = 124
+	// exitCodeScuttleError ... scuttle failed unexpectedly, for example while waiting on the child process
+	exitCodeScuttleError = 125
+	// exitCodeCannotExecute ... the child process could not be started
+	exitCodeCannotExecute = 126
+	// exitCodeCommandNotFound ... the command passed to scuttle could not be found
+	exitCodeCommandNotFound = 127
+)
+
+// Makes sure the sidecars are only stopped once, whichever path scuttle exits through
+var shutdownOnce sync.Once
+
+// The exit code picked by the first call to shutdownAndExitAfterChild, every caller exits with it
+var shutdownExitCode int
+
+// Signals that stop scuttle before the child process has started
+var terminationSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
 func main() {
 	config = getConfig()
 
 	log(fmt.Sprintf("Scuttle %s starting up, pid %d", Version, os.Getpid()))
 
-	if len(os.Args) < 2 {
+	for _, warning := range config.Warnings {
+		fmt.Fprintf(os.Stderr, "scuttle: warning, %s\n", warning)
+	}
+	if config.TerminationGracePeriod > time.Duration(0) {
+		log(fmt.Sprintf("TERMINATION_GRACE_PERIOD: %s, the child has %s to exit and %s is reserved for the sidecars", config.TerminationGracePeriod, config.ChildGracePeriod, config.ShutdownTimeout))
+	}
+
+	// The child is not started with an invalid config, but the sidecars are still stopped so the pod can finish.
+	// An invalid SHUTDOWN_CHAIN is left unset, the sidecar drivers stop the sidecars instead.
+	if len(config.InvalidSettings) > 0 {
+		for _, setting := range config.InvalidSettings {
+			fmt.Fprintf(os.Stderr, "scuttle: invalid configuration, %s\n", setting)
+		}
+		shutdownAndExit(exitCodeInvalidConfig)
+	}
+
+	commands, err := splitCommands(os.Args[1:], config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scuttle: invalid configuration, %s\n", err)
+		shutdownAndExit(exitCodeInvalidConfig)
+	}
+	if len(commands) == 0 {
 		log("No arguments received, exiting")
 		return
 	}
@@ -43,201 +84,347 @@ func main() {
 		log("Logging is now enabled")
 	}
 
-	// If an envoy API was set and config is set to wait on envoy
-	if config.EnvoyAdminAPI != "" {
-		if blockingCtx := waitForEnvoy(); blockingCtx != nil {
-			<-blockingCtx.Done()
-			err := blockingCtx.Err()
-			if err == nil || errors.Is(err, context.Canceled) {
-				log("Blocking finished, Envoy has started")
-			} else if errors.Is(err, context.DeadlineExceeded) && config.QuitWithoutEnvoyTimeout > time.Duration(0) {
-				log("Blocking timeout reached and Envoy has not started, exiting scuttle")
-				os.Exit(1)
-			} else if errors.Is(err, context.DeadlineExceeded) {
-				log("Blocking timeout reached and Envoy has not started, continuing with passed in executable")
-			} else {
-				panic(err.Error())
-			}
+	// Unexpected failures still stop the sidecars
+	defer func() {
+		if r := recover(); r != nil {
+			log(fmt.Sprintf("Unexpected error: %v", r))
+			shutdownAndExit(exitCodeScuttleError)
 		}
-	}
-
-	// Find the executable the user wants to run
-	binary, err := exec.LookPath(os.Args[1])
-	if err != nil {
-		panic(err)
-	}
+	}()
 
-	var proc *os.Process
+	children := newChildProcesses()
+	interruptCtx, interrupt := context.WithCancel(context.Background())
 	stop := make(chan os.Signal, 2)
-	signal.Notify(stop, syscall.SIGINT) // Only listen to SIGINT until after child proc starts
-	
-	// Pass signals to the child process
-	// This takes an OS signal and passes to the child process scuttle starts (proc)
+	signal.Notify(stop, terminationSignals...) // Only listen to termination signals until after child proc starts
+
+	// Pass signals to the child processes
+	// This takes an OS signal and passes to the child processes scuttle starts
 	go func() {
-		
 		for sig := range stop {
 			if sig == syscall.SIGURG {
 				// SIGURG is used by Golang for it's own purposes, ignore it as these signals
 				// are most likely "junk" from Golang not from K8s/Docker
 				log(fmt.Sprintf("Received signal '%v', ignoring", sig))
-			} else if proc == nil {
-				// Signal received before the process even started. Let's just exit.
-				log(fmt.Sprintf("Received signal '%v', exiting", sig))
-				kill(1) // Attempt to stop sidecars if configured
+				continue
+			}
+			firstTermination := terminationStarted(sig)
+
+			if sig == syscall.SIGCHLD && config.ReapChildren {
+				// Acting as init, the children's exit status comes from reaping rather than proc.Wait(), see reapOnSignal()
+				continue
+			}
+
+			running := children.list(func() {
+				if isTerminationSignal(sig) && interruptCtx.Err() == nil {
+					// Signal received before the process even started, or between attempts, stop waiting and exit
+					log(fmt.Sprintf("Received signal '%v' while the child process is not running, exiting", sig))
+					interrupt()
+				}
+			})
+			if len(running) == 0 {
+				continue
+			}
+
+			// The child processes are running and should also receive this signal
+			forwarded, ok := forwardedSignal(config, sig.(syscall.Signal))
+			if !ok {
+				log(fmt.Sprintf("Received signal '%v', dropped by SIGNAL_DROP", sig))
+				forwarded = 0
+			} else if forwarded != sig {
+				log(fmt.Sprintf("Received signal '%v', passing to %s as %s", sig, childrenName(len(running)), signalName(forwarded)))
 			} else {
-				// Proc is not null, so the child process is running and should also receive this signal
-				log(fmt.Sprintf("Received signal '%v', passing to child", sig))
-				proc.Signal(sig)
+				log(fmt.Sprintf("Received signal '%v', passing to %s", sig, childrenName(len(running))))
+			}
+			for _, child := range running {
+				if forwarded != 0 {
+					signalChild(child.proc, forwarded)
+				}
+				if firstTermination {
+					startChildGracePeriod(child.proc, child.stopped, config.ChildGracePeriod)
+				}
 			}
 		}
 	}()
 
-	// Start process passed in by user
-	proc, err = os.StartProcess(binary, os.Args[1:], &os.ProcAttr{
-		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
-	})
-	if err != nil {
-		panic(err)
+	// If sidecars are configured and config is set to wait on them
+	if blockingCtx := waitForEnvoy(interruptCtx); blockingCtx != nil {
+		<-blockingCtx.Done()
+		err := blockingCtx.Err()
+		if interruptCtx.Err() != nil {
+			exitInterrupted()
+		} else if err == nil || errors.Is(err, context.Canceled) {
+			log("Blocking finished, Envoy has started")
+		} else if errors.Is(err, context.DeadlineExceeded) && config.QuitWithoutEnvoyTimeout > time.Duration(0) {
+			log("Blocking timeout reached and Envoy has not started, exiting scuttle")
+			shutdownAndExit(exitCodeEnvoyTimeout)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			log("Blocking timeout reached and Envoy has not started, continuing with passed in executable")
+		} else {
+			log(fmt.Sprintf("Blocking failed, error: %s", err))
+			shutdownAndExit(exitCodeScuttleError)
+		}
 	}
 
-	// Once child process starts, listen for any symbol and pass to the child proc
-	signal.Notify(stop)
+	// Find the executables the user wants to run, before running any of them
+	binaries := make([]string, len(commands))
+	for i, command := range commands {
+		binary, err := exec.LookPath(command[0])
+		if err != nil {
+			log(fmt.Sprintf("Could not find command '%s', error: %s", command[0], err))
+			shutdownAndExit(exitCodeCommandNotFound)
+		}
+		binaries[i] = binary
+	}
 
-	state, err := proc.Wait()
-	if err != nil {
-		panic(err)
+	if config.ReapChildren {
+		if err := setChildSubreaper(); err != nil {
+			log(fmt.Sprintf("Could not become a child subreaper, only direct children will be reaped, error: %s", err))
+		}
+		go children.reapOnSignal()
+	}
+
+	// Runs a command as a child process, retried with backoff on failure as CHILD_RETRIES allows until ctx is done
+	runCommand := func(ctx context.Context, binary string, args []string) (int, syscall.Signal) {
+		retries := childRetryBackOff(ctx, config)
+		for attempt := 1; ; attempt++ {
+			// Start process passed in by user, unless a signal arrived in the meantime
+			child, err := children.start(ctx, binary, args)
+			if interruptCtx.Err() != nil {
+				exitInterrupted()
+			}
+			if ctx.Err() != nil {
+				log(fmt.Sprintf("Not starting '%s', the commands are being stopped", binary))
+				return 0, 0
+			}
+			if err != nil {
+				log(fmt.Sprintf("Could not start '%s', error: %s", binary, err))
+				shutdownAndExit(exitCodeCannotExecute)
+			}
+
+			// Once child process starts, listen for any symbol and pass to the child proc
+			signal.Notify(stop)
+
+			status, err := children.wait(child)
+			if err != nil {
+				log(fmt.Sprintf("Could not wait on the child process, error: %s", err))
+				shutdownAndExit(exitCodeScuttleError)
+			}
+
+			exitCode, sig := childExitStatus(status)
+			if !waitToRetryChild(ctx, retries, attempt, exitCode, sig) {
+				return exitCode, sig
+			}
+		}
 	}
 
-	exitCode := state.ExitCode()
+	// Done once MAX_RUNTIME is reached, so no more commands are started or retried
+	runCtx, stopCommands := context.WithCancel(interruptCtx)
+	enforceMaxRuntime(children, stopCommands)
 
-	kill(exitCode)
+	if config.CommandMode == CommandModeParallel {
+		exitCode, sig := runParallelCommands(runCtx, children, runCommand, binaries, commands)
+		shutdownAndExitAfterChild(maxRuntimeExitCode(exitCode, sig))
+	}
 
-	os.Exit(exitCode)
+	// The commands run one after the other, the sidecars are only stopped after the last one.
+	// Scuttle exits with the exit code of the first command that failed.
+	exitCode, sig := 0, syscall.Signal(0)
+	for i, command := range commands {
+		if len(commands) > 1 {
+			if i > 0 && !terminationTime().IsZero() {
+				log(fmt.Sprintf("Skipping the remaining %d commands, scuttle received SIGTERM or SIGINT", len(commands)-i))
+				break
+			}
+			if i > 0 && runCtx.Err() != nil {
+				log(fmt.Sprintf("Skipping the remaining %d commands, MAX_RUNTIME was reached", len(commands)-i))
+				break
+			}
+			log(fmt.Sprintf("Starting command %d of %d: %s", i+1, len(commands), strings.Join(command, " ")))
+		}
+
+		commandExitCode, commandSig := runCommand(runCtx, binaries[i], command)
+		if len(commands) > 1 {
+			log(fmt.Sprintf("Command %d of %d '%s' exited with exit code %d", i+1, len(commands), command[0], commandExitCode))
+		}
+		if commandExitCode == 0 {
+			continue
+		}
+		if exitCode == 0 {
+			exitCode, sig = commandExitCode, commandSig
+		}
+		if config.CommandFailureMode != CommandFailureContinue && i < len(commands)-1 {
+			log(fmt.Sprintf("Skipping the remaining %d commands after the failure, COMMAND_FAILURE_MODE is %s", len(commands)-i-1, config.CommandFailureMode))
+			break
+		}
+	}
+	shutdownAndExitAfterChild(maxRuntimeExitCode(exitCode, sig))
 }
 
-func kill(exitCode int) {
-	var logLineUnformatted = "Kill received: (Action: %s, Reason: %s, Exit Code: %d)"
-	switch {
-	case config.EnvoyAdminAPI == "":
-		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "ENVOY_ADMIN_API not set", exitCode))
-	case !strings.Contains(config.EnvoyAdminAPI, "127.0.0.1") && !strings.Contains(config.EnvoyAdminAPI, "localhost"):
-		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "ENVOY_ADMIN_API is not a localhost or 127.0.0.1", exitCode))
-	case config.NeverKillIstio:
-		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "NEVER_KILL_ISTIO is true", exitCode))
-	case config.NeverKillIstioOnFailure && exitCode != 0:
-		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "NEVER_KILL_ISTIO_ON_FAILURE is true", exitCode))
-		os.Exit(exitCode)
-	case config.IstioQuitAPI == "":
-		// No istio API sent, fallback to Pkill method
-		log(fmt.Sprintf(logLineUnformatted, "Stopping Istio with pkill", "ISTIO_QUIT_API is not set", exitCode))
-		killGenericEndpoints()
-		killIstioWithPkill()
-	default:
-		// Stop istio using api
-		log(fmt.Sprintf(logLineUnformatted, "Stopping Istio with API", "ISTIO_QUIT_API is set", exitCode))
-		killGenericEndpoints()
-		killIstioWithAPI()
+// childrenName ... how the child processes are called in the logs
+func childrenName(count int) string {
+	if count == 1 {
+		return "child"
 	}
+	return fmt.Sprintf("%d children", count)
 }
 
-func killGenericEndpoints() {
-	if len(config.GenericQuitEndpoints) == 0 {
+// signalChild ... passes sig to the child process, or to its whole process group with CHILD_PROCESS_GROUP
+func signalChild(child *os.Process, sig os.Signal) {
+	if !config.ChildProcessGroup {
+		child.Signal(sig)
 		return
 	}
+	if err := syscall.Kill(-child.Pid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+		log(fmt.Sprintf("Could not send '%v' to the child's process group, error: %s", sig, err))
+	}
+}
 
-	for _, genericEndpoint := range config.GenericQuitEndpoints {
-		genericEndpoint = strings.Trim(genericEndpoint, " ")
-		resp := typhon.NewRequest(context.Background(), "POST", genericEndpoint, nil).Send().Response()
-		if resp.Error != nil {
-			log(fmt.Sprintf("Sent POST to '%s', error: %s", genericEndpoint, resp.Error))
-			continue
-		}
-		log(fmt.Sprintf("Sent POST to '%s', status code: %d", genericEndpoint, resp.StatusCode))
+// childExitStatus ... the child's exit code, following the shell convention of 128+signo
+// when it was terminated by a signal, and that signal
+func childExitStatus(status syscall.WaitStatus) (int, syscall.Signal) {
+	if !status.Signaled() {
+		log(fmt.Sprintf("Child process exited with exit code %d", status.ExitStatus()))
+		return status.ExitStatus(), 0
 	}
+	exitCode := 128 + int(status.Signal())
+	log(fmt.Sprintf("Child process terminated by signal %s (core dumped: %t), exit code %d", signalName(status.Signal()), status.CoreDump(), exitCode))
+	return exitCode, status.Signal()
+}
+
+// exitInterrupted ... stops the sidecars and exits after a signal was received before the child started
+func exitInterrupted() {
+	log(fmt.Sprintf("Interrupted before the child process started, exiting with exit code %d", exitCodeInterrupted))
+	shutdownAndExit(exitCodeInterrupted)
 }
 
-func killIstioWithAPI() {
-	log(fmt.Sprintf("Stopping Istio using Istio API '%s' (intended for Istio >v1.2)", config.IstioQuitAPI))
+// shutdownAndExit ... the single way out of scuttle once it is running, stops the sidecars
+// exactly once (even when called from several goroutines) and exits with exitCode
+func shutdownAndExit(exitCode int) {
+	shutdownAndExitAfterChild(exitCode, 0)
+}
 
-	url := fmt.Sprintf("%s/quitquitquit", config.IstioQuitAPI)
-	resp := typhon.NewRequest(context.Background(), "POST", url, nil).Send().Response()
-	responseSuccess := false
+// shutdownAndExitAfterChild ... like shutdownAndExit, sig is the signal that terminated the child process, if any.
+// EXIT_CODE_POLICY can change the exit code.
+func shutdownAndExitAfterChild(exitCode int, sig syscall.Signal) {
+	shutdownOnce.Do(func() {
+		shutdownExitCode = kill(exitCode, sig)
+	})
+	os.Exit(shutdownExitCode)
+}
 
-	if resp.Error != nil {
-		log(fmt.Sprintf("Sent quitquitquit to Istio, error: %d", resp.Error))
-	} else {
-		log(fmt.Sprintf("Sent quitquitquit to Istio, status code: %d", resp.StatusCode))
-		responseSuccess = resp.StatusCode == 200
+// kill ... stops the sidecars unless EXIT_CODE_POLICY or the NEVER_KILL_ISTIO settings keep them running,
+// returns the exit code scuttle should exit with
+func kill(exitCode int, sig syscall.Signal) int {
+	var logLineUnformatted = "Kill received: (Action: %s, Reason: %s, Exit Code: %d)"
+	if sig != 0 {
+		logLineUnformatted = "Kill received: (Action: %s, Reason: %s, Exit Code: %d, Signal: " + signalName(sig) + ")"
+	}
+	finalExitCode := exitCode
+	policyAction := ""
+	if rule, ok := matchExitCodePolicy(config.ExitCodePolicy, exitCode, sig); ok {
+		policyAction = rule.Action
+		if rule.RewriteExitCode {
+			finalExitCode = rule.ExitCode
+		}
+		log(fmt.Sprintf("EXIT_CODE_POLICY rule '%s' matched exit code %d, sidecars: %s, scuttle exit code: %d", rule.Spec, exitCode, rule.Action, finalExitCode))
 	}
 
-	if !responseSuccess && config.IstioFallbackPkill {
-		log(fmt.Sprintf("quitquitquit failed, will attempt pkill method"))
-		killIstioWithPkill()
+	drivers := getDrivers(config)
+	switch {
+	case policyAction == policyKeep:
+		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "EXIT_CODE_POLICY keeps the sidecars", exitCode))
+	case len(config.ShutdownChain) == 0 && len(drivers) == 0 && len(config.SidecarDrivers) == 0:
+		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "ENVOY_ADMIN_API not set", exitCode))
+	case len(config.ShutdownChain) == 0 && len(drivers) == 0:
+		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "No valid SIDECAR_DRIVERS", exitCode))
+	case len(config.ShutdownChain) == 0 && len(config.SidecarDrivers) == 0 && !isLocalAPI(config.EnvoyAdminAPI) && config.EnvoyAdminAPI != "":
+		// The default drivers, GENERIC_QUIT_ENDPOINTS included, belong to the Envoy sidecar, which is not in this pod
+		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "ENVOY_ADMIN_API is not a localhost or 127.0.0.1", exitCode))
+	case policyAction != policyKill && config.NeverKillIstio:
+		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "NEVER_KILL_ISTIO is true", exitCode))
+	case policyAction != policyKill && config.NeverKillIstioOnFailure && exitCode != 0:
+		log(fmt.Sprintf(logLineUnformatted, "Skipping Istio kill", "NEVER_KILL_ISTIO_ON_FAILURE is true", exitCode))
+		os.Exit(finalExitCode)
+	case len(config.ShutdownChain) > 0:
+		log(fmt.Sprintf(logLineUnformatted, "Stopping sidecars", "Shutdown chain: "+shutdownChainNames(config.ShutdownChain), exitCode))
+		withinShutdownBudget(func(ctx context.Context) {
+			if err := runShutdownChain(ctx, config, config.ShutdownChain, exitCode); err != nil {
+				log(fmt.Sprintf("Shutdown chain could not stop the sidecars, error: %s", err))
+			}
+		})
+	default:
+		log(fmt.Sprintf(logLineUnformatted, "Stopping sidecars", "Sidecar drivers: "+driverNames(drivers), exitCode))
+		withinShutdownBudget(func(ctx context.Context) {
+			shutdownSidecars(ctx, drivers, exitCode)
+		})
 	}
+	return finalExitCode
 }
 
-func killIstioWithPkill() {
-	log("Stopping Istio using pkill command (intended for Istio <v1.3)")
-
-	cmd := exec.Command("sh", "-c", "pkill -SIGINT pilot-agent")
-	_, err := cmd.Output()
-	if err == nil {
-		log("Process pilot-agent successfully stopped")
+// withinShutdownBudget ... runs shutdown, returning once it is done or SHUTDOWN_TIMEOUT is reached (or what is
+// left of TERMINATION_GRACE_PERIOD), so scuttle exits in time even if a sidecar does not answer
+func withinShutdownBudget(shutdown func(ctx context.Context)) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	budget, limited := shutdownBudget(config, terminationTime())
+	if limited {
+		ctx, cancel = context.WithTimeout(context.Background(), budget)
 	} else {
-		errorMessage := err.Error()
-		log("pilot-agent could not be stopped, err: " + errorMessage)
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				log(fmt.Sprintf("Unexpected error while stopping the sidecars: %v", r))
+			}
+		}()
+		shutdown(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log(fmt.Sprintf("Shutdown budget of %s used up, abandoning the remaining shutdown calls", budget))
 	}
 }
 
-func waitForEnvoy() context.Context {
+// shutdownSidecars ... stops each sidecar in the order the drivers are configured
+func shutdownSidecars(ctx context.Context, drivers []SidecarDriver, exitCode int) {
+	for _, driver := range drivers {
+		if err := driver.Shutdown(ctx, exitCode); err != nil {
+			log(fmt.Sprintf("Sidecar driver '%s' could not stop its sidecar, error: %s", driver.Name(), err))
+			continue
+		}
+		log(fmt.Sprintf("Sidecar driver '%s' stopped its sidecar", driver.Name()))
+	}
+}
+
+// waitForEnvoy ... starts waiting for the configured sidecars and READY_ENDPOINTS to become ready.
+// Returns nil if there is nothing to wait for, otherwise a context that is done once
+// the targets are ready (see READY_MODE), the timeout is reached or parent is done.
+func waitForEnvoy(parent context.Context) context.Context {
 	if config.StartWithoutEnvoy {
 		return nil
 	}
+	targets := getReadinessTargets(config)
+	if len(targets) == 0 {
+		return nil
+	}
 	var blockingCtx context.Context
 	var cancel context.CancelFunc
 	if config.QuitWithoutEnvoyTimeout > time.Duration(0) {
-		blockingCtx, cancel = context.WithTimeout(context.Background(), config.QuitWithoutEnvoyTimeout)
+		blockingCtx, cancel = context.WithTimeout(parent, config.QuitWithoutEnvoyTimeout)
 	} else if config.WaitForEnvoyTimeout > time.Duration(0) {
-		blockingCtx, cancel = context.WithTimeout(context.Background(), config.WaitForEnvoyTimeout)
+		blockingCtx, cancel = context.WithTimeout(parent, config.WaitForEnvoyTimeout)
 	} else {
-		blockingCtx, cancel = context.WithCancel(context.Background())
+		blockingCtx, cancel = context.WithCancel(parent)
 	}
 
 	log("Blocking until Envoy starts")
-	go pollEnvoy(blockingCtx, cancel)
+	go waitForTargets(blockingCtx, cancel, targets, config.ReadyMode)
 	return blockingCtx
 }
-
-func pollEnvoy(ctx context.Context, cancel context.CancelFunc) {
-	url := fmt.Sprintf("%s/server_info", config.EnvoyAdminAPI)
-	pollCount := 0
-	b := backoff.NewExponentialBackOff()
-	// We wait forever for envoy to start. In practice k8s will kill the pod if we take too long.
-	b.MaxElapsedTime = config.WaitForEnvoyTimeout
-
-	if config.QuitWithoutEnvoyTimeout > time.Duration(0) {
-		b.MaxElapsedTime = config.QuitWithoutEnvoyTimeout
-	}
-
-	_ = backoff.Retry(func() error {
-		pollCount++
-		rsp := typhon.NewRequest(ctx, "GET", url, nil).Send().Response()
-
-		info := &ServerInfo{}
-
-		err := rsp.Decode(info)
-		if err != nil {
-			log(fmt.Sprintf("Polling Envoy (%d), error: %s", pollCount, err))
-			return err
-		}
-
-		if info.State != "LIVE" {
-			log(fmt.Sprintf("Polling Envoy (%d), status: Not ready yet", pollCount))
-			return errors.New("not live yet")
-		}
-
-		return nil
-	}, b)
-	// Notify the context that it's done, if it has not already been cancelled
-	cancel()
-}
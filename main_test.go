@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -16,16 +23,62 @@ var (
 	goodEventuallyServer *httptest.Server
 	badServer            *httptest.Server
 	genericQuitServer    *httptest.Server
+	envoyQuitServer      *httptest.Server
+	envoyQuitRequests    int32 = 0
+	drainingEnvoyServer  *httptest.Server
+	drainStatsPolls      int32 = 0
+	stuckEnvoyServer     *httptest.Server
+	envoyAdminServer     *httptest.Server
+	istioAgentServer     *httptest.Server
 	testsInit            bool  = false
 	envoyDelayTimestamp  int64 = 0
 	envoyDelayMax        int64 = 15
 )
 
+// Envoy /clusters?format=json response, db has one healthy and one ejected host, cache has none
+const envoyClustersJSON = `{
+ "cluster_statuses": [
+  {
+   "name": "outbound|5432||db.prod.svc.cluster.local",
+   "host_statuses": [
+    {"health_status": {"eds_health_status": "HEALTHY"}},
+    {"health_status": {"eds_health_status": "HEALTHY", "failed_outlier_check": true}}
+   ]
+  },
+  {
+   "name": "outbound|6379||cache.prod.svc.cluster.local",
+   "host_statuses": [
+    {"health_status": {"eds_health_status": "UNHEALTHY"}}
+   ]
+  }
+ ]
+}`
+
+// Envoy /listeners?format=json response
+const envoyListenersJSON = `{
+ "listener_statuses": [
+  {"name": "virtualOutbound", "local_address": {"socket_address": {"address": "0.0.0.0", "port_value": 15001}}},
+  {"name": "0.0.0.0_8080", "local_address": {"socket_address": {"address": "0.0.0.0", "port_value": 8080}}}
+ ]
+}`
+
+// Envoy /stats?format=json response, CDS and LDS have synced but the control plane is disconnected
+const envoyXDSStatsJSON = `{
+ "stats": [
+  {"name": "cluster_manager.cds.update_success", "value": 2},
+  {"name": "listener_manager.lds.update_success", "value": 1},
+  {"name": "control_plane.connected_state", "value": 0},
+  {"histograms": {"supported_quantiles": [0, 25, 50, 75, 90, 95, 99, 99.5, 99.9, 100]}}
+ ]
+}`
+
 // Sets up minimum env variables and mock http servers
 // Can be called multiple times, but will only init once per test session
 func initTestingEnv() {
 	// Always update env variables for new test
 	os.Setenv("SCUTTLE_LOGGING", "true")
+	// The test servers keep answering after a quit, only the quit tests verify it
+	os.Setenv("QUIT_VERIFY_TIMEOUT", "0s")
 	config = getConfig()
 
 	// Do not restart http servers for each test
@@ -62,6 +115,62 @@ func initTestingEnv() {
 		w.WriteHeader(http.StatusOK)
 	}))
 
+	// Counts POSTs to /quitquitquit, like Envoy's admin API
+	envoyQuitServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/quitquitquit" {
+			atomic.AddInt32(&envoyQuitRequests, 1)
+			w.Write([]byte("OK\n"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	// Reports active connections and requests for 2 /stats polls after draining starts, then only the xDS stream
+	drainingEnvoyServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthcheck/fail", "/drain_listeners":
+			atomic.StoreInt32(&drainStatsPolls, 0)
+			w.Write([]byte("OK\n"))
+		case "/stats":
+			active := 0
+			if atomic.AddInt32(&drainStatsPolls, 1) <= 2 {
+				active = 1
+			}
+			fmt.Fprintf(w, "http.admin.downstream_cx_active: 1\ncluster.xds-grpc.upstream_rq_active: 1\nhttp.inbound.downstream_cx_active: %d\ncluster.db.upstream_rq_active: %d\n", active, active)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	// Always reports active connections and requests
+	stuckEnvoyServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("http.inbound.downstream_cx_active: 3\ncluster.db.upstream_rq_active: 2\n"))
+	}))
+
+	// Live Envoy with clusters, used for readiness checks past /server_info
+	envoyAdminServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server_info":
+			w.Write([]byte("{\"state\": \"LIVE\"}"))
+		case "/clusters":
+			w.Write([]byte(envoyClustersJSON))
+		case "/listeners":
+			w.Write([]byte(envoyListenersJSON))
+		case "/stats":
+			w.Write([]byte(envoyXDSStatsJSON))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	// Ready Istio pilot-agent
+	istioAgentServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz/ready" {
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
 	testsInit = true
 }
 
@@ -70,7 +179,7 @@ func initTestingEnv() {
 // Pass in a negative integer to block but skip kill
 func initAndRun(exitCode int) {
 	initTestingEnv()
-	if blockingCtx := waitForEnvoy(); blockingCtx != nil {
+	if blockingCtx := waitForEnvoy(context.Background()); blockingCtx != nil {
 		<-blockingCtx.Done()
 		err := blockingCtx.Err()
 		if err == nil || errors.Is(err, context.Canceled) {
@@ -82,7 +191,7 @@ func initAndRun(exitCode int) {
 		}
 	}
 	if exitCode >= 0 {
-		kill(exitCode)
+		kill(exitCode, 0)
 	}
 }
 
@@ -120,7 +229,7 @@ func TestGenericQuitEndpoints(t *testing.T) {
 	// notaurl^^ is to verify a malformatted URL does not result in panic
 	os.Setenv("GENERIC_QUIT_ENDPOINTS", "https://google.com/, https://github.com/, 127.0.0.1:1111/idontexist, notaurl^^ ")
 	initTestingEnv()
-	killGenericEndpoints()
+	newGenericDriver(config).Shutdown(context.Background(), 0)
 }
 
 // Tests scuttle does not fail when the /quitquitquit endpoint does not return a response
@@ -129,7 +238,7 @@ func TestNoQuitQuitQuitResponse(t *testing.T) {
 	os.Setenv("START_WITHOUT_ENVOY", "false")
 	os.Setenv("ISTIO_QUIT_API", "127.0.0.1:1111/idontexist")
 	initTestingEnv()
-	killIstioWithAPI()
+	newIstioDriver(config).Shutdown(context.Background(), 0)
 }
 
 // Tests scuttle does not fail when the /quitquitquit endpoint is not a valid URL
@@ -138,7 +247,7 @@ func TestNoQuitQuitQuitMalformedUrl(t *testing.T) {
 	os.Setenv("START_WITHOUT_ENVOY", "false")
 	os.Setenv("ISTIO_QUIT_API", "notaurl^^")
 	initTestingEnv()
-	killIstioWithAPI()
+	newIstioDriver(config).Shutdown(context.Background(), 0)
 }
 
 // Tests scuttle waits
@@ -149,7 +258,7 @@ func TestWaitTillTimeoutForEnvoy(t *testing.T) {
 	initTestingEnv()
 	dur, _ := time.ParseDuration("500ms")
 	config.QuitWithoutEnvoyTimeout = dur
-	blockingCtx := waitForEnvoy()
+	blockingCtx := waitForEnvoy(context.Background())
 	if blockingCtx == nil {
 		t.Fatal("Blocking context was nil")
 	}
@@ -169,7 +278,7 @@ func TestWaitForEnvoyTimeoutContinueWithoutEnvoy(t *testing.T) {
 	os.Setenv("WAIT_FOR_ENVOY_TIMEOUT", "5s")
 	os.Setenv("ENVOY_ADMIN_API", badServer.URL)
 	initTestingEnv()
-	blockingCtx := waitForEnvoy()
+	blockingCtx := waitForEnvoy(context.Background())
 	<-blockingCtx.Done()
 	err := blockingCtx.Err()
 	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
@@ -180,3 +289,538 @@ func TestWaitForEnvoyTimeoutContinueWithoutEnvoy(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Tests Envoy's /quitquitquit is sent to the Envoy admin API
+func TestEnvoyQuitQuitQuit(t *testing.T) {
+	fmt.Println("Starting TestEnvoyQuitQuitQuit")
+	initTestingEnv()
+	os.Setenv("ENVOY_ADMIN_API", envoyQuitServer.URL)
+	initTestingEnv()
+	before := atomic.LoadInt32(&envoyQuitRequests)
+	if err := newEnvoyDriver(config).Shutdown(context.Background(), 0); err != nil {
+		t.Fatal("quitquitquit to Envoy was not successful")
+	}
+	if atomic.LoadInt32(&envoyQuitRequests) != before+1 {
+		t.Fatal("Envoy did not receive quitquitquit")
+	}
+}
+
+// Tests scuttle does not fail when Envoy's /quitquitquit endpoint does not return a response
+func TestNoEnvoyQuitQuitQuitResponse(t *testing.T) {
+	fmt.Println("Starting TestNoEnvoyQuitQuitQuitResponse")
+	os.Setenv("ENVOY_ADMIN_API", "127.0.0.1:1111/idontexist")
+	initTestingEnv()
+	if err := newEnvoyDriver(config).Shutdown(context.Background(), 0); err == nil {
+		t.Fatal("quitquitquit to a nonexistent Envoy reported success")
+	}
+}
+
+// Tests kill() stops Envoy with its admin API when ISTIO_QUIT_API is not set
+func TestKillEnvoyWithoutIstioQuitAPI(t *testing.T) {
+	fmt.Println("Starting TestKillEnvoyWithoutIstioQuitAPI")
+	initTestingEnv()
+	os.Setenv("ENVOY_ADMIN_API", envoyQuitServer.URL)
+	os.Setenv("ISTIO_QUIT_API", "")
+	os.Setenv("GENERIC_QUIT_ENDPOINTS", "")
+	os.Setenv("SIDECAR_QUIT_METHOD", "auto")
+	initTestingEnv()
+	before := atomic.LoadInt32(&envoyQuitRequests)
+	kill(0, 0)
+	if atomic.LoadInt32(&envoyQuitRequests) != before+1 {
+		t.Fatal("Envoy did not receive quitquitquit")
+	}
+}
+
+// Tests the default drivers, generic endpoints included, are skipped when ENVOY_ADMIN_API is not local
+func TestKillSkippedWithRemoteEnvoy(t *testing.T) {
+	fmt.Println("Starting TestKillSkippedWithRemoteEnvoy")
+	initTestingEnv()
+	os.Setenv("ENVOY_ADMIN_API", "http://envoy.example.com:15000")
+	os.Setenv("GENERIC_QUIT_ENDPOINTS", envoyQuitServer.URL+"/quitquitquit")
+	os.Setenv("SIDECAR_DRIVERS", "")
+	initTestingEnv()
+	defer os.Setenv("GENERIC_QUIT_ENDPOINTS", "")
+	before := atomic.LoadInt32(&envoyQuitRequests)
+	kill(0, 0)
+	if atomic.LoadInt32(&envoyQuitRequests) != before {
+		t.Fatal("Generic quit endpoint was called with a remote ENVOY_ADMIN_API")
+	}
+}
+
+// Tests SIDECAR_QUIT_METHOD=envoy-api uses Envoy's admin API even when ISTIO_QUIT_API is set
+func TestKillEnvoyWithQuitMethod(t *testing.T) {
+	fmt.Println("Starting TestKillEnvoyWithQuitMethod")
+	initTestingEnv()
+	os.Setenv("ENVOY_ADMIN_API", envoyQuitServer.URL)
+	os.Setenv("ISTIO_QUIT_API", "127.0.0.1:1111/idontexist")
+	os.Setenv("GENERIC_QUIT_ENDPOINTS", "")
+	os.Setenv("SIDECAR_QUIT_METHOD", "envoy-api")
+	initTestingEnv()
+	before := atomic.LoadInt32(&envoyQuitRequests)
+	kill(0, 0)
+	if atomic.LoadInt32(&envoyQuitRequests) != before+1 {
+		t.Fatal("Envoy did not receive quitquitquit")
+	}
+	os.Setenv("SIDECAR_QUIT_METHOD", "")
+}
+
+// Tests Envoy is drained once its active connections and requests reach zero
+func TestDrainEnvoy(t *testing.T) {
+	fmt.Println("Starting TestDrainEnvoy")
+	initTestingEnv()
+	os.Setenv("ENVOY_ADMIN_API", drainingEnvoyServer.URL)
+	os.Setenv("ENVOY_DRAIN_TIMEOUT", "5s")
+	initTestingEnv()
+	if !drainEnvoy(context.Background(), config) {
+		t.Fatal("Envoy did not drain before the deadline")
+	}
+	if atomic.LoadInt32(&drainStatsPolls) < 3 {
+		t.Fatal("Drain finished before Envoy reported no active connections")
+	}
+	os.Setenv("ENVOY_DRAIN_TIMEOUT", "")
+}
+
+// Tests draining gives up once ENVOY_DRAIN_TIMEOUT is reached
+func TestDrainEnvoyDeadline(t *testing.T) {
+	fmt.Println("Starting TestDrainEnvoyDeadline")
+	initTestingEnv()
+	os.Setenv("ENVOY_ADMIN_API", stuckEnvoyServer.URL)
+	os.Setenv("ENVOY_DRAIN_TIMEOUT", "500ms")
+	initTestingEnv()
+	started := time.Now()
+	if drainEnvoy(context.Background(), config) {
+		t.Fatal("Envoy with active connections reported as drained")
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Fatal("Drain did not stop at the deadline")
+	}
+	os.Setenv("ENVOY_DRAIN_TIMEOUT", "")
+}
+
+// Runs scuttle's main() with the arguments after "--" when started by startScuttle
+func TestScuttleHelperProcess(t *testing.T) {
+	if os.Getenv("SCUTTLE_TEST_HELPER_PROCESS") != "1" {
+		return
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"scuttle"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+	os.Exit(0)
+}
+
+// Starts scuttle in a separate process with the given env, and returns once it logs waitFor
+func startScuttle(t *testing.T, env []string, waitFor string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestScuttleHelperProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "SCUTTLE_TEST_HELPER_PROCESS=1", "SCUTTLE_LOGGING=true")
+	cmd.Env = append(cmd.Env, env...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(chan bool)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		seen := false
+		for scanner.Scan() {
+			fmt.Println("scuttle process:", scanner.Text())
+			if !seen && strings.Contains(scanner.Text(), waitFor) {
+				seen = true
+				found <- true
+			}
+		}
+		if !seen {
+			close(found)
+		}
+	}()
+
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Fatalf("scuttle process exited before logging '%s'", waitFor)
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("scuttle process did not log '%s'", waitFor)
+	}
+	return cmd
+}
+
+// Returns the exit code of a scuttle process started with startScuttle
+func waitForScuttle(t *testing.T, cmd *exec.Cmd) int {
+	done := make(chan error)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("scuttle process did not exit")
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// scuttleRun ... a scuttle process started with startScuttleWithSidecar
+type scuttleRun struct {
+	cmd         *exec.Cmd
+	quitsBefore int32
+}
+
+// Starts scuttle like startScuttle, with a sidecar stopped through envoyQuitServer's /quitquitquit.
+// env is added to the sidecar's settings, and overrides them.
+func startScuttleWithSidecar(t *testing.T, env []string, waitFor string, args ...string) *scuttleRun {
+	sidecarEnv := []string{
+		"GENERIC_QUIT_ENDPOINTS=" + envoyQuitServer.URL + "/quitquitquit",
+		"START_WITHOUT_ENVOY=true",
+		"SIDECAR_DRIVERS=generic",
+		"NEVER_KILL_ISTIO=false",
+	}
+	quitsBefore := atomic.LoadInt32(&envoyQuitRequests)
+	return &scuttleRun{cmd: startScuttle(t, append(sidecarEnv, env...), waitFor, args...), quitsBefore: quitsBefore}
+}
+
+// Returns the exit code of the scuttle process, and how many times it stopped the sidecar
+func (r *scuttleRun) wait(t *testing.T) (int, int32) {
+	exitCode := waitForScuttle(t, r.cmd)
+	return exitCode, atomic.LoadInt32(&envoyQuitRequests) - r.quitsBefore
+}
+
+// Tests termination signals received while waiting for Envoy stop the sidecars and exit scuttle
+func TestSignalWhileWaitingForEnvoy(t *testing.T) {
+	fmt.Println("Starting TestSignalWhileWaitingForEnvoy")
+	initTestingEnv()
+	env := []string{
+		"ENVOY_ADMIN_API=" + badServer.URL,
+		"START_WITHOUT_ENVOY=false",
+		"WAIT_FOR_ENVOY_TIMEOUT=",
+		"QUIT_WITHOUT_ENVOY_TIMEOUT=",
+		"SIDECAR_DRIVERS=",
+	}
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP} {
+		run := startScuttleWithSidecar(t, env, "Blocking until Envoy starts", "echo", "child should not run")
+		run.cmd.Process.Signal(sig)
+		exitCode, stops := run.wait(t)
+		if exitCode != exitCodeInterrupted {
+			t.Errorf("%v: expected exit code %d, got %d", sig, exitCodeInterrupted, exitCode)
+		}
+		if stops != 1 {
+			t.Errorf("%v: sidecars were not stopped", sig)
+		}
+	}
+}
+
+// Tests scuttle stops the sidecars and exits with its own exit code when the child cannot run
+func TestChildFailureStopsSidecars(t *testing.T) {
+	fmt.Println("Starting TestChildFailureStopsSidecars")
+	initTestingEnv()
+	notExecutable, err := ioutil.TempFile("", "scuttle-not-executable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(notExecutable.Name())
+	notExecutable.WriteString("not a program\n")
+	notExecutable.Close()
+	os.Chmod(notExecutable.Name(), 0755)
+
+	env := []string{"ENVOY_ADMIN_API=" + badServer.URL}
+	tests := []struct {
+		command  string
+		exitCode int
+	}{
+		{"idontexist-scuttle-command", exitCodeCommandNotFound},
+		{notExecutable.Name(), exitCodeCannotExecute},
+	}
+	for _, test := range tests {
+		exitCode, stops := startScuttleWithSidecar(t, env, "starting up", test.command).wait(t)
+		if exitCode != test.exitCode {
+			t.Errorf("%s: expected exit code %d, got %d", test.command, test.exitCode, exitCode)
+		}
+		if stops != 1 {
+			t.Errorf("%s: sidecars were not stopped exactly once", test.command)
+		}
+	}
+}
+
+// Tests a child terminated by a signal gives exit code 128+signo, and still stops the sidecars
+func TestChildSignalExitCode(t *testing.T) {
+	fmt.Println("Starting TestChildSignalExitCode")
+	initTestingEnv()
+	for _, sig := range []syscall.Signal{syscall.SIGKILL, syscall.SIGTERM} {
+		exitCode, stops := startScuttleWithSidecar(t, nil, "starting up", "sh", "-c", fmt.Sprintf("kill -%d $$", sig)).wait(t)
+		if exitCode != 128+int(sig) {
+			t.Errorf("%v: expected exit code %d, got %d", sig, 128+int(sig), exitCode)
+		}
+		if stops != 1 {
+			t.Errorf("%v: sidecars were not stopped", sig)
+		}
+	}
+
+	// Rules naming a signal only match signal deaths, not the same exit code
+	env := []string{"EXIT_CODE_POLICY=SIGKILL=keep"}
+	exitCode, stops := startScuttleWithSidecar(t, env, "starting up", "sh", "-c", "exit 137").wait(t)
+	if exitCode != 137 || stops != 1 {
+		t.Errorf("Expected exit code 137 with the sidecars stopped, got %d (%d stops)", exitCode, stops)
+	}
+	exitCode, stops = startScuttleWithSidecar(t, env, "starting up", "sh", "-c", "kill -9 $$").wait(t)
+	if exitCode != 137 || stops != 0 {
+		t.Errorf("Expected exit code 137 with the sidecars kept, got %d (%d stops)", exitCode, stops)
+	}
+}
+
+// Tests REAP_CHILDREN reaps orphans left by the child, and still returns the child's own exit code
+func TestReapChildren(t *testing.T) {
+	fmt.Println("Starting TestReapChildren")
+	env := []string{"START_WITHOUT_ENVOY=true", "SIDECAR_DRIVERS=", "REAP_CHILDREN=true"}
+	cmd := startScuttle(t, env, "Reaped orphaned process", "sh", "-c", "(sh -c 'exit 0' &); sleep 0.3; exit 3")
+	if exitCode := waitForScuttle(t, cmd); exitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", exitCode)
+	}
+}
+
+// Tests CHILD_PROCESS_GROUP forwards signals to the processes started by the child too
+func TestChildProcessGroup(t *testing.T) {
+	fmt.Println("Starting TestChildProcessGroup")
+	sleep, err := ioutil.ReadFile("/bin/sleep")
+	if err != nil {
+		t.Skip("No /bin/sleep to start a grandchild with")
+	}
+	dir, err := ioutil.TempDir("", "scuttle-process-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	grandchild := filepath.Join(dir, "scuttle-test-grandchild")
+	if err := ioutil.WriteFile(grandchild, sleep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer stopProcesses(context.Background(), "scuttle-test-grandchild", syscall.SIGKILL, time.Second)
+
+	env := []string{"START_WITHOUT_ENVOY=true", "SIDECAR_DRIVERS=", "CHILD_PROCESS_GROUP=true"}
+	cmd := startScuttle(t, env, "grandchild started", "sh", "-c", grandchild+" 30 & echo grandchild started; wait")
+	cmd.Process.Signal(syscall.SIGTERM)
+	if exitCode := waitForScuttle(t, cmd); exitCode != 128+int(syscall.SIGTERM) {
+		t.Errorf("Expected exit code %d, got %d", 128+int(syscall.SIGTERM), exitCode)
+	}
+	if pids, _ := findProcesses("scuttle-test-grandchild"); len(waitForProcesses(context.Background(), pids, time.Second)) != 0 {
+		t.Error("The grandchild did not receive the forwarded signal")
+	}
+}
+
+// Tests the forwarding loop drops SIGNAL_DROP signals and translates SIGNAL_MAP ones
+func TestSignalMapForwarding(t *testing.T) {
+	fmt.Println("Starting TestSignalMapForwarding")
+	env := []string{"START_WITHOUT_ENVOY=true", "SIDECAR_DRIVERS=", "SIGNAL_MAP=TERM:USR1", "SIGNAL_DROP=HUP"}
+	cmd := startScuttle(t, env, "child ready", "sh", "-c", "trap 'exit 7' USR1; echo child ready; while :; do sleep 0.1; done")
+	cmd.Process.Signal(syscall.SIGHUP)
+	cmd.Process.Signal(syscall.SIGTERM)
+	if exitCode := waitForScuttle(t, cmd); exitCode != 7 {
+		t.Errorf("Expected the child to exit from the translated SIGUSR1 with exit code 7, got %d", exitCode)
+	}
+
+	cmd = startScuttle(t, []string{"SIGNAL_MAP=TERM:NOPE"}, "starting up", "echo", "child should not run")
+	if exitCode := waitForScuttle(t, cmd); exitCode != exitCodeInvalidConfig {
+		t.Errorf("Expected exit code %d for an invalid SIGNAL_MAP, got %d", exitCodeInvalidConfig, exitCode)
+	}
+}
+
+// Tests a child ignoring the forwarded SIGTERM is killed once CHILD_GRACE_PERIOD is over
+func TestChildGracePeriod(t *testing.T) {
+	fmt.Println("Starting TestChildGracePeriod")
+	initTestingEnv()
+	env := []string{"CHILD_PROCESS_GROUP=true", "CHILD_GRACE_PERIOD=200ms"}
+	run := startScuttleWithSidecar(t, env, "child ready", "sh", "-c", "trap '' TERM; echo child ready; while :; do sleep 0.1; done")
+	started := time.Now()
+	run.cmd.Process.Signal(syscall.SIGTERM)
+	exitCode, stops := run.wait(t)
+	if exitCode != 128+int(syscall.SIGKILL) {
+		t.Errorf("Expected exit code %d, got %d", 128+int(syscall.SIGKILL), exitCode)
+	}
+	if elapsed := time.Since(started); elapsed < 200*time.Millisecond {
+		t.Errorf("Child was killed before the grace period, after %s", elapsed)
+	}
+	if stops != 1 {
+		t.Error("Sidecars were not stopped")
+	}
+
+	// SIGHUP is passed on as a reload, it does not start the grace period
+	run = startScuttleWithSidecar(t, env, "child ready", "sh", "-c", "trap '' HUP; trap 'exit 9' TERM; echo child ready; while :; do sleep 0.1; done")
+	run.cmd.Process.Signal(syscall.SIGHUP)
+	time.Sleep(400 * time.Millisecond)
+	run.cmd.Process.Signal(syscall.SIGTERM)
+	if exitCode, _ := run.wait(t); exitCode != 9 {
+		t.Errorf("Expected the child to exit from SIGTERM with exit code 9, got %d", exitCode)
+	}
+}
+
+// Tests the child is retried on the listed exit codes, and the sidecars are only stopped after the final attempt
+func TestChildRetries(t *testing.T) {
+	fmt.Println("Starting TestChildRetries")
+	initTestingEnv()
+	dir, err := ioutil.TempDir("", "scuttle-child-retries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := []string{
+		"CHILD_RETRIES=2",
+		"CHILD_RETRY_INTERVAL=10ms",
+		"CHILD_RETRY_EXIT_CODES=3",
+	}
+	tests := []struct {
+		succeedOn int
+		failWith  int
+		attempts  string
+		exitCode  int
+	}{
+		{3, 3, "3", 0},
+		{5, 3, "3", 3},
+		{5, 4, "1", 4},
+	}
+	for i, test := range tests {
+		attempts := filepath.Join(dir, fmt.Sprintf("attempts-%d", i))
+		script := fmt.Sprintf("n=$(($(cat %[1]s 2>/dev/null || echo 0) + 1)); echo $n > %[1]s; [ $n -ge %[2]d ] && exit 0; exit %[3]d", attempts, test.succeedOn, test.failWith)
+		exitCode, stops := startScuttleWithSidecar(t, env, "starting up", "sh", "-c", script).wait(t)
+		if exitCode != test.exitCode {
+			t.Errorf("Test %d: expected exit code %d, got %d", i, test.exitCode, exitCode)
+		}
+		if count, _ := ioutil.ReadFile(attempts); strings.TrimSpace(string(count)) != test.attempts {
+			t.Errorf("Test %d: expected %s attempts, got %s", i, test.attempts, count)
+		}
+		if stops != 1 {
+			t.Errorf("Test %d: sidecars were not stopped exactly once", i)
+		}
+	}
+
+	// A termination signal while waiting to retry ends the retries
+	env = append(env, "CHILD_RETRY_INTERVAL=10s")
+	run := startScuttleWithSidecar(t, env, "Retrying the child process", "sh", "-c", "exit 3")
+	run.cmd.Process.Signal(syscall.SIGTERM)
+	if exitCode, _ := run.wait(t); exitCode != 3 {
+		t.Errorf("Expected the exit code of the last attempt, got %d", exitCode)
+	}
+}
+
+// Tests commands run in order within one sidecar lifetime, stopping or continuing after a failure
+func TestCommandSequence(t *testing.T) {
+	fmt.Println("Starting TestCommandSequence")
+	initTestingEnv()
+	dir, err := ioutil.TempDir("", "scuttle-command-sequence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := []string{"COMMAND_DELIMITER=;;"}
+	tests := []struct {
+		failureMode string
+		ran         string
+	}{
+		{CommandFailureStop, "1\n"},
+		{CommandFailureContinue, "1\n3\n"},
+	}
+	for _, test := range tests {
+		ran := filepath.Join(dir, test.failureMode)
+		exitCode, stops := startScuttleWithSidecar(t, append(env, "COMMAND_FAILURE_MODE="+test.failureMode), "starting up",
+			"sh", "-c", "echo 1 >> "+ran, ";;", "sh", "-c", "exit 4", ";;", "sh", "-c", "echo 3 >> "+ran).wait(t)
+		if exitCode != 4 {
+			t.Errorf("%s: expected the exit code of the failed command, got %d", test.failureMode, exitCode)
+		}
+		if contents, _ := ioutil.ReadFile(ran); string(contents) != test.ran {
+			t.Errorf("%s: expected the commands %q to run, got %q", test.failureMode, test.ran, contents)
+		}
+		if stops != 1 {
+			t.Errorf("%s: sidecars were not stopped exactly once", test.failureMode)
+		}
+	}
+
+	// Signals go to the command that is running
+	// The marker is built by the script, so it is not matched by scuttle logging the command
+	run := startScuttleWithSidecar(t, env, "second command ready", "true", ";;", "sh", "-c", "trap 'exit 9' USR1; state=ready; echo second command $state; while :; do sleep 0.1; done")
+	run.cmd.Process.Signal(syscall.SIGUSR1)
+	if exitCode, _ := run.wait(t); exitCode != 9 {
+		t.Errorf("Expected the second command to receive the signal and exit with 9, got %d", exitCode)
+	}
+}
+
+// Tests parallel commands finish with the main command or with all of them, and all receive the signals
+func TestParallelCommands(t *testing.T) {
+	fmt.Println("Starting TestParallelCommands")
+	initTestingEnv()
+	dir, err := ioutil.TempDir("", "scuttle-parallel-commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := []string{
+		"COMMAND_DELIMITER=;;",
+		"COMMAND_MODE=parallel",
+		"CHILD_GRACE_PERIOD=1s",
+	}
+	helper := "while :; do sleep 0.05; done"
+
+	// The helper is stopped once the main command exits, its exit code does not count
+	exitCode, stops := startScuttleWithSidecar(t, append(env, "PARALLEL_EXIT_CODE=max"), "starting up", "sh", "-c", "sleep 0.2; exit 3", ";;", "sh", "-c", helper).wait(t)
+	if exitCode != 3 {
+		t.Errorf("Expected the main command's exit code 3, got %d", exitCode)
+	}
+	if stops != 1 {
+		t.Error("Sidecars were not stopped exactly once")
+	}
+
+	// A helper ignoring SIGTERM is killed after PARALLEL_STOP_GRACE_PERIOD, without CHILD_GRACE_PERIOD
+	exitCode, stops = startScuttleWithSidecar(t, append(env, "CHILD_GRACE_PERIOD=", "PARALLEL_STOP_GRACE_PERIOD=300ms"), "starting up", "sh", "-c", "sleep 0.2; exit 3", ";;", "sh", "-c", "trap '' TERM; "+helper).wait(t)
+	if exitCode != 3 {
+		t.Errorf("Expected the main command's exit code 3, got %d", exitCode)
+	}
+	if stops != 1 {
+		t.Error("Sidecars were not stopped exactly once")
+	}
+
+	// All commands run to the end
+	exitCode, _ = startScuttleWithSidecar(t, append(env, "PARALLEL_COMPLETION=all", "PARALLEL_EXIT_CODE=max"), "starting up", "sh", "-c", "exit 0", ";;", "sh", "-c", "sleep 0.2; exit 5").wait(t)
+	if exitCode != 5 {
+		t.Errorf("Expected the highest exit code 5, got %d", exitCode)
+	}
+
+	// Signals reach every command, the second one only reports ready once the first one is running
+	first := filepath.Join(dir, "first")
+	run := startScuttleWithSidecar(t, append(env, "PARALLEL_COMPLETION=all", "PARALLEL_EXIT_CODE=max"), "both ready",
+		"sh", "-c", "trap 'exit 6' USR1; touch "+first+"; "+helper, ";;",
+		"sh", "-c", "trap 'exit 7' USR1; while [ ! -f "+first+" ]; do sleep 0.05; done; state=ready; echo both $state; "+helper)
+	run.cmd.Process.Signal(syscall.SIGUSR1)
+	if exitCode, _ := run.wait(t); exitCode != 7 {
+		t.Errorf("Expected both commands to exit from the signal with exit code 7, got %d", exitCode)
+	}
+}
+
+// Tests the child is stopped once MAX_RUNTIME is reached, killed after MAX_RUNTIME_GRACE_PERIOD, and scuttle exits with 124
+func TestMaxRuntime(t *testing.T) {
+	fmt.Println("Starting TestMaxRuntime")
+	initTestingEnv()
+	env := []string{
+		"CHILD_PROCESS_GROUP=true",
+		"MAX_RUNTIME=200ms",
+		"MAX_RUNTIME_GRACE_PERIOD=200ms",
+		"COMMAND_DELIMITER=--then",
+		"COMMAND_FAILURE_MODE=continue",
+	}
+	started := time.Now()
+	exitCode, stops := startScuttleWithSidecar(t, env, "Deadline hit", "sh", "-c", "trap '' TERM; while :; do sleep 0.1; done", "--then", "sh", "-c", "exit 3").wait(t)
+	if exitCode != exitCodeMaxRuntime {
+		t.Errorf("Expected exit code %d, got %d", exitCodeMaxRuntime, exitCode)
+	}
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Errorf("Child was killed before the grace period, after %s", elapsed)
+	}
+	if stops != 1 {
+		t.Error("Sidecars were not stopped")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// maxRuntimeReached ... set to 1 once MAX_RUNTIME is over
+var maxRuntimeReached int32
+
+// enforceMaxRuntime ... once MAX_RUNTIME is over, calls stopCommands so no more commands are started or retried,
+// and stops the running child processes, killing them after MAX_RUNTIME_GRACE_PERIOD
+func enforceMaxRuntime(children *childProcesses, stopCommands context.CancelFunc) {
+	if config.MaxRuntime <= time.Duration(0) {
+		return
+	}
+	time.AfterFunc(config.MaxRuntime, func() {
+		atomic.StoreInt32(&maxRuntimeReached, 1)
+		log(fmt.Sprintf("Deadline hit: MAX_RUNTIME of %s reached, stopping the child processes", config.MaxRuntime))
+		stopCommands()
+		stopChildren(children, config.MaxRuntimeGracePeriod)
+	})
+}
+
+// maxRuntimeExitCode ... exitCodeMaxRuntime once MAX_RUNTIME was reached, otherwise the child's exit code and signal
+func maxRuntimeExitCode(exitCode int, sig syscall.Signal) (int, syscall.Signal) {
+	if atomic.LoadInt32(&maxRuntimeReached) == 0 {
+		return exitCode, sig
+	}
+	log(fmt.Sprintf("Child processes stopped after MAX_RUNTIME of %s, exiting with exit code %d instead of %d", config.MaxRuntime, exitCodeMaxRuntime, exitCode))
+	return exitCodeMaxRuntime, 0
+}
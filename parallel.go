@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// When parallel commands are done, selected with PARALLEL_COMPLETION
+const (
+	// ParallelCompletionMain ... once the first command, the main one, exits. The others are stopped.
+	ParallelCompletionMain = "main"
+	// ParallelCompletionAll ... once every command has exited
+	ParallelCompletionAll = "all"
+)
+
+// Which exit code scuttle exits with after parallel commands, selected with PARALLEL_EXIT_CODE
+const (
+	// ParallelExitCodeMain ... the exit code of the first command, the main one
+	ParallelExitCodeMain = "main"
+	// ParallelExitCodeFirstFailure ... the exit code of the first command to fail, 0 if none did
+	ParallelExitCodeFirstFailure = "first-failure"
+	// ParallelExitCodeMax ... the highest exit code
+	ParallelExitCodeMax = "max"
+)
+
+// commandResult ... how the command at index exited
+type commandResult struct {
+	index    int
+	exitCode int
+	sig      syscall.Signal
+}
+
+// runParallelCommands ... starts every command at once, and waits for them following PARALLEL_COMPLETION.
+// Returns the exit code following PARALLEL_EXIT_CODE, commands stopped by scuttle do not count towards it.
+func runParallelCommands(ctx context.Context, children *childProcesses, run func(context.Context, string, []string) (int, syscall.Signal), binaries []string, commands [][]string) (int, syscall.Signal) {
+	// Done once the other commands are stopped, so they are not started or retried any more
+	ctx, stopOthers := context.WithCancel(ctx)
+	defer stopOthers()
+
+	results := make(chan commandResult, len(commands))
+	for i := range commands {
+		log(fmt.Sprintf("Starting command %d of %d: %s", i+1, len(commands), strings.Join(commands[i], " ")))
+		go func(i int) {
+			exitCode, sig := run(ctx, binaries[i], commands[i])
+			results <- commandResult{index: i, exitCode: exitCode, sig: sig}
+		}(i)
+	}
+
+	counted := make([]commandResult, 0, len(commands))
+	stopping := false
+	for range commands {
+		result := <-results
+		log(fmt.Sprintf("Command %d of %d '%s' exited with exit code %d", result.index+1, len(commands), commands[result.index][0], result.exitCode))
+		if stopping {
+			continue
+		}
+		counted = append(counted, result)
+		if result.index == 0 && config.ParallelCompletion == ParallelCompletionMain && len(counted) < len(commands) {
+			log("The main command exited, stopping the other commands")
+			stopping = true
+			stopOthers()
+			stopChildren(children, parallelStopGracePeriod(config))
+		}
+	}
+	return parallelExitCode(config.ParallelExitCode, counted)
+}
+
+// stopChildren ... asks the running child processes to exit with SIGTERM, following SIGNAL_MAP,
+// and kills them once grace is over
+func stopChildren(children *childProcesses, grace time.Duration) {
+	sig, ok := forwardedSignal(config, syscall.SIGTERM)
+	if !ok {
+		sig = syscall.SIGTERM
+	}
+	for _, child := range children.list(nil) {
+		log(fmt.Sprintf("Sending %s to child process %d", signalName(sig), child.proc.Pid))
+		signalChild(child.proc, sig)
+		startChildGracePeriod(child.proc, child.stopped, grace)
+	}
+}
+
+// parallelStopGracePeriod ... how long the other commands have to exit once the main one has,
+// PARALLEL_STOP_GRACE_PERIOD or CHILD_GRACE_PERIOD if that is shorter
+func parallelStopGracePeriod(cfg ScuttleConfig) time.Duration {
+	if cfg.ChildGracePeriod > time.Duration(0) && (cfg.ParallelStopGracePeriod <= time.Duration(0) || cfg.ChildGracePeriod < cfg.ParallelStopGracePeriod) {
+		return cfg.ChildGracePeriod
+	}
+	return cfg.ParallelStopGracePeriod
+}
+
+// parallelExitCode ... the exit code and terminating signal picked by rule out of the results, in the order the commands exited
+func parallelExitCode(rule string, results []commandResult) (int, syscall.Signal) {
+	exitCode, sig := 0, syscall.Signal(0)
+	for _, result := range results {
+		switch {
+		case rule == ParallelExitCodeMain && result.index == 0:
+			return result.exitCode, result.sig
+		case rule == ParallelExitCodeFirstFailure && result.exitCode != 0:
+			return result.exitCode, result.sig
+		case rule == ParallelExitCodeMax && result.exitCode > exitCode:
+			exitCode, sig = result.exitCode, result.sig
+		}
+	}
+	return exitCode, sig
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// Tests the exit code is picked out of the commands' results following PARALLEL_EXIT_CODE
+func TestParallelExitCode(t *testing.T) {
+	fmt.Println("Starting TestParallelExitCode")
+	results := []commandResult{{1, 0, 0}, {2, 137, syscall.SIGKILL}, {0, 3, 0}, {3, 4, 0}}
+	tests := []struct {
+		rule     string
+		results  []commandResult
+		exitCode int
+		sig      syscall.Signal
+	}{
+		{ParallelExitCodeMain, results, 3, 0},
+		{ParallelExitCodeFirstFailure, results, 137, syscall.SIGKILL},
+		{ParallelExitCodeMax, results, 137, syscall.SIGKILL},
+		{ParallelExitCodeFirstFailure, []commandResult{{0, 0, 0}, {1, 0, 0}}, 0, 0},
+		{ParallelExitCodeMax, []commandResult{{1, 2, 0}, {0, 0, 0}}, 2, 0},
+	}
+	for _, test := range tests {
+		if exitCode, sig := parallelExitCode(test.rule, test.results); exitCode != test.exitCode || sig != test.sig {
+			t.Errorf("%s %v: expected exit code %d and signal %d, got %d and %d", test.rule, test.results, test.exitCode, test.sig, exitCode, sig)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How often /proc is checked while waiting for processes to exit
+const processPollInterval = 100 * time.Millisecond
+
+// signalsByName ... the signals that can be given by name in the config, without the SIG prefix
+var signalsByName = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"SYS":    syscall.SIGSYS,
+}
+
+// parseSignal ... accepts a signal name with or without the SIG prefix, such as SIGTERM or TERM, or its number
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.Trim(name, " "))
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	if sig, ok := signalsByName[strings.TrimPrefix(name, "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal '%s'", name)
+}
+
+// findProcesses ... scans /proc for running processes named name, matching the
+// process' command (like pkill) or the base name of its first argument
+func findProcesses(name string) ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("could not list processes: %w", err)
+	}
+
+	pids := make([]int, 0)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if processName(pid) == name && processRunning(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// processName ... the name of a process from /proc, empty if it has exited
+func processName(pid int) string {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err == nil && len(cmdline) > 0 {
+		return filepath.Base(strings.SplitN(string(cmdline), "\x00", 2)[0])
+	}
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// processRunning ... false once the process has exited, including when it is a zombie waiting to be reaped
+func processRunning(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command, which is in brackets and may contain spaces
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
+
+// stopProcesses ... sends sig to every process named name, then escalates to SIGTERM and SIGKILL
+// for the processes still running after each wait. Returns an error unless they all exited.
+func stopProcesses(ctx context.Context, name string, sig syscall.Signal, wait time.Duration) error {
+	pids, err := findProcesses(name)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("no running process named '%s'", name)
+	}
+
+	for _, next := range escalationSignals(sig) {
+		for _, pid := range pids {
+			log(fmt.Sprintf("Sending %s to process %s (pid %d)", signalName(next), name, pid))
+			if err := syscall.Kill(pid, next); err != nil && err != syscall.ESRCH {
+				log(fmt.Sprintf("Could not send %s to process %s (pid %d), error: %s", signalName(next), name, pid, err))
+			}
+		}
+
+		pids = waitForProcesses(ctx, pids, wait)
+		if len(pids) == 0 {
+			log(fmt.Sprintf("Process %s successfully stopped", name))
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("process %s still running (pids %v)", name, pids)
+}
+
+// escalationSignals ... sig followed by the stronger of SIGTERM and SIGKILL, so SIGTERM is
+// only sent after weaker signals and nothing follows SIGKILL
+func escalationSignals(sig syscall.Signal) []syscall.Signal {
+	switch sig {
+	case syscall.SIGKILL:
+		return []syscall.Signal{sig}
+	case syscall.SIGTERM:
+		return []syscall.Signal{sig, syscall.SIGKILL}
+	default:
+		return []syscall.Signal{sig, syscall.SIGTERM, syscall.SIGKILL}
+	}
+}
+
+// waitForProcesses ... waits up to wait for the processes to exit, returns the ones still running
+func waitForProcesses(ctx context.Context, pids []int, wait time.Duration) []int {
+	deadline := time.Now().Add(wait)
+	for {
+		running := make([]int, 0, len(pids))
+		for _, pid := range pids {
+			if processRunning(pid) {
+				running = append(running, pid)
+			}
+		}
+		if len(running) == 0 || time.Now().After(deadline) {
+			return running
+		}
+		pids = running
+
+		select {
+		case <-ctx.Done():
+			return running
+		case <-time.After(processPollInterval):
+		}
+	}
+}
+
+// signalName ... the conventional name of a signal, such as SIGTERM
+func signalName(sig syscall.Signal) string {
+	for name, known := range signalsByName {
+		if known == sig {
+			return "SIG" + name
+		}
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
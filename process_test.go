@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Starts a dummy process named name, ignoring SIGINT and SIGTERM if stubborn
+func startDummyProcess(t *testing.T, name string, stubborn bool) *exec.Cmd {
+	// Blocks reading stdin rather than looping over sleep, whose forks would briefly share the name
+	script := "echo ready; read line"
+	if stubborn {
+		script = "trap '' INT TERM; " + script
+	}
+	cmd := &exec.Cmd{Path: "/bin/sh", Args: []string{name, "-c", script}}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		stdin.Close()
+		cmd.Wait()
+	})
+	// Signals are only trapped once the script is running
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+// Tests signal names are parsed with or without the SIG prefix
+func TestParseSignal(t *testing.T) {
+	fmt.Println("Starting TestParseSignal")
+	tests := map[string]syscall.Signal{
+		"SIGINT":  syscall.SIGINT,
+		"term":    syscall.SIGTERM,
+		" KILL ":  syscall.SIGKILL,
+		"SIGUSR1": syscall.SIGUSR1,
+		"15":      syscall.SIGTERM,
+	}
+	for name, expected := range tests {
+		if sig, err := parseSignal(name); err != nil || sig != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", name, signalName(expected), signalName(sig), err)
+		}
+	}
+	for _, name := range []string{"", "SIGNOPE", "-1"} {
+		if _, err := parseSignal(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// Tests processes are found by name and stopped with the first signal
+func TestStopProcesses(t *testing.T) {
+	fmt.Println("Starting TestStopProcesses")
+	first := startDummyProcess(t, "scuttle-test-dummy", false)
+	second := startDummyProcess(t, "scuttle-test-dummy", false)
+
+	pids, err := findProcesses("scuttle-test-dummy")
+	if err != nil || len(pids) != 2 {
+		t.Fatalf("Expected 2 processes, got %v (%v)", pids, err)
+	}
+
+	start := time.Now()
+	if err := stopProcesses(context.Background(), "scuttle-test-dummy", syscall.SIGINT, 2*time.Second); err != nil {
+		t.Fatalf("Expected the processes to be stopped, got: %s", err)
+	}
+	if time.Since(start) > 1*time.Second {
+		t.Fatal("Processes were not stopped by SIGINT")
+	}
+	for _, cmd := range []*exec.Cmd{first, second} {
+		if processRunning(cmd.Process.Pid) {
+			t.Fatalf("Process %d is still running", cmd.Process.Pid)
+		}
+	}
+
+	if err := stopProcesses(context.Background(), "scuttle-test-dummy", syscall.SIGINT, 2*time.Second); err == nil {
+		t.Fatal("Expected an error with no process to stop")
+	}
+}
+
+// Tests processes ignoring SIGINT and SIGTERM are stopped with SIGKILL
+func TestStopProcessesEscalates(t *testing.T) {
+	fmt.Println("Starting TestStopProcessesEscalates")
+	cmd := startDummyProcess(t, "scuttle-test-stubborn", true)
+
+	if err := stopProcesses(context.Background(), "scuttle-test-stubborn", syscall.SIGINT, 200*time.Millisecond); err != nil {
+		t.Fatalf("Expected the process to be stopped, got: %s", err)
+	}
+	// The process is a zombie until it is reaped
+	cmd.Wait()
+	if status := cmd.ProcessState.Sys().(syscall.WaitStatus); status.Signal() != syscall.SIGKILL {
+		t.Fatalf("Expected the process to be killed by SIGKILL, got: %s", cmd.ProcessState)
+	}
+}
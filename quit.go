@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cenk/backoff"
+	"github.com/monzo/typhon"
+)
+
+// How often a sidecar is checked while waiting for it to stop
+const stopPollInterval = 250 * time.Millisecond
+
+// How long each check waits for the sidecar to answer
+const stopPollTimeout = 1 * time.Second
+
+// The first wait between retried quits and generic quit endpoint calls, growing exponentially for further retries
+var retryInitialInterval = 500 * time.Millisecond
+
+// quitStrategy ... one way of asking a sidecar to stop, such as an admin API call or a signal
+type quitStrategy struct {
+	name string
+	quit func(ctx context.Context) error
+}
+
+// stopSidecar ... runs the strategies in order until one of them stops the sidecar. Unless QUIT_VERIFY_TIMEOUT
+// is 0 and the sidecar has a liveURL, a strategy only succeeds once liveURL stops answering, and is
+// retried with backoff up to QUIT_RETRIES times before falling back to the next one.
+func stopSidecar(ctx context.Context, cfg ScuttleConfig, target string, liveURL string, strategies []quitStrategy) error {
+	verify := cfg.QuitVerifyTimeout > time.Duration(0) && liveURL != ""
+
+	for i, strategy := range strategies {
+		if i > 0 {
+			log(fmt.Sprintf("Stopping %s with %s failed, falling back to %s", target, strategies[i-1].name, strategy.name))
+		}
+
+		attempt := 0
+		err := backoff.Retry(func() error {
+			attempt++
+			if err := strategy.quit(ctx); err != nil {
+				return err
+			}
+			if !verify {
+				return nil
+			}
+			if waitForStop(ctx, liveURL, cfg.QuitVerifyTimeout) {
+				return nil
+			}
+			log(fmt.Sprintf("%s still answering at '%s' %s after %s (attempt %d)", target, liveURL, cfg.QuitVerifyTimeout, strategy.name, attempt))
+			return errors.New("sidecar still running")
+		}, quitBackOff(ctx, cfg, verify))
+
+		if err != nil {
+			continue
+		}
+		if verify {
+			log(fmt.Sprintf("%s stopped with %s, verified '%s' is no longer answering", target, strategy.name, liveURL))
+		} else {
+			log(fmt.Sprintf("%s asked to stop with %s, not verified", target, strategy.name))
+		}
+		return nil
+	}
+
+	if verify {
+		log(fmt.Sprintf("%s could not be verified as stopped, '%s' is still answering", target, liveURL))
+		return fmt.Errorf("%s still running after %s", target, strategyNames(strategies))
+	}
+	return fmt.Errorf("%s could not be stopped with %s", target, strategyNames(strategies))
+}
+
+// quitBackOff ... retries a strategy up to QUIT_RETRIES times, only when its result is verified
+func quitBackOff(ctx context.Context, cfg ScuttleConfig, verify bool) backoff.BackOff {
+	if !verify {
+		return retryBackOff(ctx, 0, retryInitialInterval)
+	}
+	return retryBackOff(ctx, cfg.QuitRetries, retryInitialInterval)
+}
+
+// retryBackOff ... exponential backoff starting at interval, stopping after retries or straight away when retries is 0
+func retryBackOff(ctx context.Context, retries int, interval time.Duration) backoff.BackOff {
+	if retries <= 0 {
+		// WithMaxRetries treats 0 as no limit
+		return backoff.WithContext(&backoff.StopBackOff{}, ctx)
+	}
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = interval
+	b.MaxElapsedTime = 0
+	b.Reset()
+	return backoff.WithContext(backoff.WithMaxRetries(b, uint64(retries)), ctx)
+}
+
+// waitForStop ... polls liveURL until it no longer answers, returns false if it still does after timeout.
+// Any response counts as running, a sidecar that has stopped refuses the connection.
+func waitForStop(ctx context.Context, liveURL string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		pollCtx, pollCancel := context.WithTimeout(ctx, stopPollTimeout)
+		rsp := typhon.NewRequest(pollCtx, "GET", liveURL, nil).Send().Response()
+		// A sidecar that does not answer in time is still running
+		stopped := rsp.Error != nil && pollCtx.Err() == nil
+		pollCancel()
+		if stopped {
+			return true
+		}
+		if rsp.Body != nil {
+			rsp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(stopPollInterval):
+		}
+	}
+}
+
+// sendShutdownRequest ... sends a request made while stopping the sidecars, abandoning it once
+// timeout (SHUTDOWN_CALL_TIMEOUT by default, no limit when 0) is reached or ctx is done
+func sendShutdownRequest(ctx context.Context, req typhon.Request, timeout time.Duration) typhon.Response {
+	if timeout > time.Duration(0) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req.Context = ctx
+
+	started := time.Now()
+	rsp := req.Send().Response()
+	if rsp.Error != nil && ctx.Err() != nil {
+		log(fmt.Sprintf("%s to '%s' abandoned after %s", req.Method, req.URL, time.Since(started).Round(time.Millisecond)))
+	}
+	return rsp
+}
+
+// envoyQuitStrategies ... the ways of stopping an Envoy based sidecar, primary followed by SIDECAR_QUIT_FALLBACK
+func envoyQuitStrategies(cfg ScuttleConfig, primary string, fallbacks []string) []quitStrategy {
+	strategies := make([]quitStrategy, 0)
+	seen := map[string]bool{}
+	for _, method := range append([]string{primary}, fallbacks...) {
+		method = strings.Trim(method, " ")
+		if seen[method] {
+			continue
+		}
+		seen[method] = true
+
+		switch method {
+		case QuitMethodIstioAPI:
+			if cfg.IstioQuitAPI == "" {
+				log(fmt.Sprintf("Quit method '%s' will be ignored, ISTIO_QUIT_API is not set", method))
+				continue
+			}
+			strategies = append(strategies, quitStrategy{name: method, quit: func(ctx context.Context) error {
+				log(fmt.Sprintf("Stopping Istio using Istio API '%s' (intended for Istio >v1.2)", cfg.IstioQuitAPI))
+				if !sendQuitQuitQuit(ctx, cfg, "Istio", cfg.IstioQuitAPI) {
+					return errors.New("quitquitquit to Istio failed")
+				}
+				return nil
+			}})
+		case QuitMethodEnvoyAPI:
+			if cfg.EnvoyAdminAPI == "" {
+				log(fmt.Sprintf("Quit method '%s' will be ignored, ENVOY_ADMIN_API is not set", method))
+				continue
+			}
+			strategies = append(strategies, quitStrategy{name: method, quit: func(ctx context.Context) error {
+				log(fmt.Sprintf("Stopping Envoy using Envoy admin API '%s'", cfg.EnvoyAdminAPI))
+				if !sendQuitQuitQuit(ctx, cfg, "Envoy", cfg.EnvoyAdminAPI) {
+					return errors.New("quitquitquit to Envoy failed")
+				}
+				return nil
+			}})
+		case QuitMethodPkill:
+			strategies = append(strategies, quitStrategy{name: method, quit: func(ctx context.Context) error {
+				return killIstioWithPkill(ctx, cfg)
+			}})
+		default:
+			log(fmt.Sprintf("Unknown quit method '%s' will be ignored", method))
+		}
+	}
+	return strategies
+}
+
+// envoyLiveURL ... the URL answering for as long as an Envoy based sidecar runs, empty if there is none
+func envoyLiveURL(cfg ScuttleConfig) string {
+	switch {
+	case cfg.EnvoyAdminAPI != "":
+		return fmt.Sprintf("%s/server_info", cfg.EnvoyAdminAPI)
+	case cfg.IstioReadyAPI != "":
+		return fmt.Sprintf("%s/healthz/ready", cfg.IstioReadyAPI)
+	case cfg.IstioQuitAPI != "":
+		return fmt.Sprintf("%s/healthz/ready", cfg.IstioQuitAPI)
+	default:
+		return ""
+	}
+}
+
+func strategyNames(strategies []quitStrategy) string {
+	names := make([]string, 0, len(strategies))
+	for _, strategy := range strategies {
+		names = append(names, strategy.name)
+	}
+	return strings.Join(names, ", ")
+}
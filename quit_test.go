@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Mimics an Envoy admin API, the server is closed on /quitquitquit when stops is set
+func newStoppableEnvoyServer(stops bool, quits *int32) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server_info":
+			w.Write([]byte("{\"state\": \"LIVE\"}"))
+		case "/quitquitquit":
+			atomic.AddInt32(quits, 1)
+			w.Write([]byte("OK\n"))
+			if stops {
+				go server.Close()
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+// Tests a quit is verified once the sidecar stops answering
+func TestQuitVerified(t *testing.T) {
+	fmt.Println("Starting TestQuitVerified")
+	var quits int32
+	envoy := newStoppableEnvoyServer(true, &quits)
+	defer envoy.Close()
+	cfg := ScuttleConfig{EnvoyAdminAPI: envoy.URL, QuitVerifyTimeout: 1 * time.Second, QuitRetries: 2}
+
+	if err := newEnvoyDriver(cfg).Shutdown(context.Background(), 0); err != nil {
+		t.Fatalf("Expected Envoy to be verified as stopped, got: %s", err)
+	}
+	if atomic.LoadInt32(&quits) != 1 {
+		t.Fatalf("Expected 1 quitquitquit, got %d", quits)
+	}
+}
+
+// Tests a sidecar that keeps running is sent the quit again, then reported as still running
+func TestQuitRetriedUntilUnverified(t *testing.T) {
+	fmt.Println("Starting TestQuitRetriedUntilUnverified")
+	defer func(interval time.Duration) { retryInitialInterval = interval }(retryInitialInterval)
+	retryInitialInterval = 10 * time.Millisecond
+	var quits int32
+	envoy := newStoppableEnvoyServer(false, &quits)
+	defer envoy.Close()
+	cfg := ScuttleConfig{EnvoyAdminAPI: envoy.URL, QuitVerifyTimeout: 300 * time.Millisecond, QuitRetries: 1}
+
+	if err := newEnvoyDriver(cfg).Shutdown(context.Background(), 0); err == nil {
+		t.Fatal("Expected an error with Envoy still running")
+	}
+	if atomic.LoadInt32(&quits) != 2 {
+		t.Fatalf("Expected 2 quitquitquit, got %d", quits)
+	}
+}
+
+// Tests the next quit method is used when the sidecar is still running after the first one
+func TestQuitFallback(t *testing.T) {
+	fmt.Println("Starting TestQuitFallback")
+	var istioQuits, envoyQuits int32
+	istio := newStoppableEnvoyServer(false, &istioQuits)
+	defer istio.Close()
+	envoy := newStoppableEnvoyServer(true, &envoyQuits)
+	defer envoy.Close()
+	cfg := ScuttleConfig{
+		EnvoyAdminAPI:       envoy.URL,
+		IstioQuitAPI:        istio.URL,
+		SidecarQuitMethod:   QuitMethodAuto,
+		SidecarQuitFallback: []string{QuitMethodEnvoyAPI},
+		QuitVerifyTimeout:   300 * time.Millisecond,
+	}
+
+	if err := newIstioDriver(cfg).Shutdown(context.Background(), 0); err != nil {
+		t.Fatalf("Expected the fallback to stop Envoy, got: %s", err)
+	}
+	if atomic.LoadInt32(&istioQuits) != 1 || atomic.LoadInt32(&envoyQuits) != 1 {
+		t.Fatalf("Expected 1 quitquitquit to Istio and Envoy each, got %d and %d", istioQuits, envoyQuits)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cenk/backoff"
+	"github.com/monzo/typhon"
+)
+
+// readinessTarget ... something scuttle waits on before starting the child, every SidecarDriver is one
+type readinessTarget interface {
+	Name() string
+	WaitReady(ctx context.Context) error
+}
+
+// getReadinessTargets ... the configured sidecar drivers followed by READY_ENDPOINTS
+func getReadinessTargets(cfg ScuttleConfig) []readinessTarget {
+	targets := make([]readinessTarget, 0)
+	for _, driver := range getDrivers(cfg) {
+		targets = append(targets, driver)
+	}
+	for _, endpoint := range cfg.ReadyEndpoints {
+		targets = append(targets, &httpReadyTarget{url: strings.Trim(endpoint, " "), cfg: cfg})
+	}
+	return targets
+}
+
+// waitForTargets ... polls every target in parallel and cancels ctx once all of them (ReadyModeAll)
+// or one of them (ReadyModeAny) is ready. If that can no longer happen, ctx is left to reach its
+// deadline so the timeout is reported. Targets still polling are stopped before ctx is cancelled.
+func waitForTargets(ctx context.Context, cancel context.CancelFunc, targets []readinessTarget, mode string) {
+	// Notify the context that it's done, if it has not already been cancelled
+	defer cancel()
+
+	type result struct {
+		target readinessTarget
+		err    error
+	}
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	defer stopPolling()
+	results := make(chan result, len(targets))
+	for _, target := range targets {
+		go func(target readinessTarget) {
+			results <- result{target: target, err: target.WaitReady(pollCtx)}
+		}(target)
+	}
+
+	checked, ready, failed := 0, 0, 0
+	for received := 0; received < len(targets); received++ {
+		r := <-results
+		switch {
+		case errors.Is(r.err, errNoReadinessCheck):
+			continue
+		case r.err != nil:
+			checked++
+			failed++
+			log(fmt.Sprintf("Readiness target '%s' did not become ready, error: %s", r.target.Name(), r.err))
+		default:
+			checked++
+			ready++
+			log(fmt.Sprintf("Readiness target '%s' is ready", r.target.Name()))
+			if mode == ReadyModeAny {
+				// The other targets are no longer needed, wait for them to stop polling
+				stopPolling()
+				for received++; received < len(targets); received++ {
+					<-results
+				}
+				return
+			}
+		}
+	}
+
+	if failed > 0 || (mode == ReadyModeAny && checked > 0 && ready == 0) {
+		log(fmt.Sprintf("%d of %d readiness targets are ready", ready, checked))
+		<-ctx.Done()
+	}
+}
+
+// httpReadyTarget ... a URL from READY_ENDPOINTS, ready once it returns a 2xx status code
+type httpReadyTarget struct {
+	url string
+	cfg ScuttleConfig
+}
+
+func (t *httpReadyTarget) Name() string {
+	return t.url
+}
+
+func (t *httpReadyTarget) WaitReady(ctx context.Context) error {
+	return pollHTTPReady(ctx, t.cfg, fmt.Sprintf("'%s'", t.url), t.url, func(statusCode int) bool {
+		return statusCode >= 200 && statusCode <= 299
+	})
+}
+
+// pollHTTPReady ... polls url with backoff until its status code is accepted by ready,
+// what is the name of the target used in the logs
+func pollHTTPReady(ctx context.Context, cfg ScuttleConfig, what string, url string, ready func(statusCode int) bool) error {
+	pollCount := 0
+
+	return backoff.Retry(func() error {
+		pollCount++
+		rsp := typhon.NewRequest(ctx, "GET", url, nil).Send().Response()
+		if rsp.Error != nil {
+			log(fmt.Sprintf("Polling %s (%d), error: %s", what, pollCount, rsp.Error))
+			return rsp.Error
+		}
+		rsp.Body.Close()
+
+		if !ready(rsp.StatusCode) {
+			log(fmt.Sprintf("Polling %s (%d), status: Not ready yet (%d)", what, pollCount, rsp.StatusCode))
+			return errors.New("not ready yet")
+		}
+
+		return nil
+	}, readinessBackOff(ctx, cfg))
+}
+
+// isStatusOK ... only a 200 means ready
+func isStatusOK(statusCode int) bool {
+	return statusCode == 200
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func waitForReadiness(t *testing.T, maxWait time.Duration) error {
+	blockingCtx := waitForEnvoy(context.Background())
+	if blockingCtx == nil {
+		t.Fatal("Blocking context was nil")
+	}
+	select {
+	case <-time.After(maxWait):
+		t.Fatal("Blocking did not finish")
+	case <-blockingCtx.Done():
+	}
+	return blockingCtx.Err()
+}
+
+func setReadinessTestEnv(t *testing.T, readyMode string, readyEndpoints string) {
+	t.Setenv("ENVOY_ADMIN_API", goodServer.URL)
+	t.Setenv("LINKERD_ADMIN_API", "")
+	t.Setenv("SIDECAR_DRIVERS", "")
+	t.Setenv("START_WITHOUT_ENVOY", "false")
+	t.Setenv("WAIT_FOR_ENVOY_TIMEOUT", "")
+	t.Setenv("QUIT_WITHOUT_ENVOY_TIMEOUT", "300ms")
+	t.Setenv("READY_MODE", readyMode)
+	t.Setenv("READY_ENDPOINTS", readyEndpoints)
+	config = getConfig()
+}
+
+// Tests every target must be ready with READY_MODE=all
+func TestReadyModeAll(t *testing.T) {
+	fmt.Println("Starting TestReadyModeAll")
+	initTestingEnv()
+	setReadinessTestEnv(t, "all", genericQuitServer.URL+","+goodServer.URL)
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected all targets to be ready, got: %s", err)
+	}
+
+	setReadinessTestEnv(t, "all", genericQuitServer.URL+","+badServer.URL)
+	if err := waitForReadiness(t, 3*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout with a target that is never ready, got: %s", err)
+	}
+}
+
+// Tests one ready target is enough with READY_MODE=any
+func TestReadyModeAny(t *testing.T) {
+	fmt.Println("Starting TestReadyModeAny")
+	initTestingEnv()
+	setReadinessTestEnv(t, "any", badServer.URL)
+	t.Setenv("ENVOY_ADMIN_API", badServer.URL)
+	t.Setenv("READY_ENDPOINTS", badServer.URL+","+genericQuitServer.URL)
+	config = getConfig()
+	if err := waitForReadiness(t, 900*time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected one ready target to be enough, got: %s", err)
+	}
+
+	t.Setenv("READY_ENDPOINTS", badServer.URL)
+	config = getConfig()
+	if err := waitForReadiness(t, 3*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout when no target is ready, got: %s", err)
+	}
+}
+
+// Tests READY_ENDPOINTS are waited on without any sidecar configured
+func TestReadyEndpointsWithoutSidecar(t *testing.T) {
+	fmt.Println("Starting TestReadyEndpointsWithoutSidecar")
+	initTestingEnv()
+	setReadinessTestEnv(t, "all", genericQuitServer.URL)
+	t.Setenv("ENVOY_ADMIN_API", "")
+	config = getConfig()
+	if targets := getReadinessTargets(config); len(targets) != 1 || targets[0].Name() != genericQuitServer.URL {
+		t.Fatal("Expected READY_ENDPOINTS to be the only readiness target")
+	}
+	if err := waitForReadiness(t, 2*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the endpoint to be ready, got: %s", err)
+	}
+}
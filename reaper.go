@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// How often children are reaped without a SIGCHLD, in case one was missed
+const reapInterval = 1 * time.Second
+
+// reapOnSignal ... reaps the children on every SIGCHLD, and every reapInterval in case one was missed.
+// SIGCHLD has its own channel, rather than the one signals are passed to the child from, so it is not
+// dropped when that one is full. Runs until scuttle exits.
+func (c *childProcesses) reapOnSignal() {
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		// Reap once first in case a child, or an orphan, exited before SIGCHLD was listened to
+		c.reap()
+		select {
+		case <-sigchld:
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapChildren ... reaps every child of scuttle that has exited without blocking, like an init process would.
+// Returns the wait status of each of them by pid.
+func reapChildren() map[int]syscall.WaitStatus {
+	reaped := make(map[int]syscall.WaitStatus)
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			// ECHILD once there are no children left, or 0 when the remaining ones are still running
+			return reaped
+		}
+		reaped[pid] = status
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "syscall"
+
+// prSetChildSubreaper ... PR_SET_CHILD_SUBREAPER from linux/prctl.h, not exported by the syscall package
+const prSetChildSubreaper = 36
+
+// setChildSubreaper ... makes orphaned descendants of scuttle its children instead of init's,
+// so they can be reaped when scuttle is not PID 1
+func setChildSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// setChildSubreaper ... only Linux has child subreapers, orphans are reaped by init elsewhere
+func setChildSubreaper() error {
+	return errors.New("child subreapers are only supported on Linux")
+}
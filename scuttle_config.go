@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,10 +19,76 @@ type ScuttleConfig struct {
 	NeverKillIstio          bool
 	IstioFallbackPkill      bool
 	NeverKillIstioOnFailure bool
-	GenericQuitEndpoints    []string
+	GenericQuitEndpoints    []QuitEndpoint
 	QuitWithoutEnvoyTimeout time.Duration
+	SidecarQuitMethod       string
+	EnvoyDrainTimeout       time.Duration
+	SidecarDrivers          []string
+	LinkerdAdminAPI         string
+	ReadyEndpoints          []string
+	ReadyMode               string
+	EnvoyReadyClusters      []string
+	EnvoyClustersMinHealthy int
+	EnvoyClustersTimeout    time.Duration
+	EnvoyReadyListeners     []string
+	EnvoyReadyXDS           bool
+	EnvoyReadyControlPlane  bool
+	IstioReadyAPI           string
+	IstioReadyOnly          bool
+	PkillProcessName        string
+	PkillSignal             syscall.Signal
+	PkillEscalationWait     time.Duration
+	QuitVerifyTimeout       time.Duration
+	QuitRetries             int
+	SidecarQuitFallback     []string
+	ShutdownChain           []ShutdownStep
+	ShutdownTimeout         time.Duration
+	ShutdownCallTimeout     time.Duration
+	ExitCodePolicy          []ExitCodeRule
+	ReapChildren            bool
+	ChildProcessGroup       bool
+	SignalMap               map[syscall.Signal]syscall.Signal
+	SignalDrop              []syscall.Signal
+	ChildGracePeriod        time.Duration
+	TerminationGracePeriod  time.Duration
+	ChildRetries            int
+	ChildRetryInterval      time.Duration
+	ChildRetryExitCodes     ExitCodeRule
+	CommandDelimiter        string
+	Commands                [][]string
+	CommandFailureMode      string
+	CommandMode             string
+	ParallelCompletion      string
+	ParallelExitCode        string
+	ParallelStopGracePeriod time.Duration
+	MaxRuntime              time.Duration
+	MaxRuntimeGracePeriod   time.Duration
+	// InvalidSettings ... settings that were rejected rather than ignored, scuttle will not start with any
+	InvalidSettings []string
+	// Warnings ... settings that were adjusted to work, printed once the config is loaded
+	Warnings []string
 }
 
+// Methods scuttle can use to stop the sidecar, selected with SIDECAR_QUIT_METHOD
+const (
+	// QuitMethodAuto ... uses the Istio API when ISTIO_QUIT_API is set, otherwise Envoy's admin API
+	QuitMethodAuto = "auto"
+	// QuitMethodIstioAPI ... sends /quitquitquit to ISTIO_QUIT_API (Istio >v1.2)
+	QuitMethodIstioAPI = "istio-api"
+	// QuitMethodEnvoyAPI ... sends /quitquitquit to ENVOY_ADMIN_API (plain Envoy)
+	QuitMethodEnvoyAPI = "envoy-api"
+	// QuitMethodPkill ... stops pilot-agent with pkill (Istio <v1.3)
+	QuitMethodPkill = "pkill"
+)
+
+// How the readiness targets are combined, selected with READY_MODE
+const (
+	// ReadyModeAll ... every sidecar and READY_ENDPOINTS target must be ready
+	ReadyModeAll = "all"
+	// ReadyModeAny ... the first ready target is enough
+	ReadyModeAny = "any"
+)
+
 func log(message string) {
 	if config.LoggingEnabled {
 		fmt.Printf("%s scuttle: %s\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"), message)
@@ -39,8 +107,135 @@ func getConfig() ScuttleConfig {
 		NeverKillIstio:          getBoolFromEnv("NEVER_KILL_ISTIO", false, loggingEnabled),
 		IstioFallbackPkill:      getBoolFromEnv("ISTIO_FALLBACK_PKILL", false, loggingEnabled),
 		NeverKillIstioOnFailure: getBoolFromEnv("NEVER_KILL_ISTIO_ON_FAILURE", false, loggingEnabled),
-		GenericQuitEndpoints:    getStringArrayFromEnv("GENERIC_QUIT_ENDPOINTS", make([]string, 0), loggingEnabled),
 		QuitWithoutEnvoyTimeout: getDurationFromEnv("QUIT_WITHOUT_ENVOY_TIMEOUT", time.Duration(0), loggingEnabled),
+		SidecarQuitMethod:       getChoiceFromEnv("SIDECAR_QUIT_METHOD", QuitMethodAuto, []string{QuitMethodAuto, QuitMethodIstioAPI, QuitMethodEnvoyAPI, QuitMethodPkill}, loggingEnabled),
+		EnvoyDrainTimeout:       getDurationFromEnv("ENVOY_DRAIN_TIMEOUT", time.Duration(0), loggingEnabled),
+		SidecarDrivers:          getStringArrayFromEnv("SIDECAR_DRIVERS", make([]string, 0), loggingEnabled),
+		LinkerdAdminAPI:         getStringFromEnv("LINKERD_ADMIN_API", "", loggingEnabled),
+		ReadyEndpoints:          getStringArrayFromEnv("READY_ENDPOINTS", make([]string, 0), loggingEnabled),
+		ReadyMode:               getChoiceFromEnv("READY_MODE", ReadyModeAll, []string{ReadyModeAll, ReadyModeAny}, loggingEnabled),
+		EnvoyReadyClusters:      getStringArrayFromEnv("ENVOY_READY_CLUSTERS", make([]string, 0), loggingEnabled),
+		EnvoyClustersMinHealthy: getIntFromEnv("ENVOY_READY_CLUSTERS_MIN_HEALTHY", 1, loggingEnabled),
+		EnvoyClustersTimeout:    getDurationFromEnv("ENVOY_READY_CLUSTERS_TIMEOUT", time.Duration(0), loggingEnabled),
+		EnvoyReadyListeners:     getStringArrayFromEnv("ENVOY_READY_LISTENERS", make([]string, 0), loggingEnabled),
+		EnvoyReadyXDS:           getBoolFromEnv("ENVOY_READY_XDS", false, loggingEnabled),
+		EnvoyReadyControlPlane:  getBoolFromEnv("ENVOY_READY_CONTROL_PLANE", false, loggingEnabled),
+		IstioReadyAPI:           getStringFromEnv("ISTIO_READY_API", "", loggingEnabled),
+		IstioReadyOnly:          getBoolFromEnv("ISTIO_READY_ONLY", false, loggingEnabled),
+		PkillProcessName:        getStringFromEnv("PKILL_PROCESS_NAME", "pilot-agent", loggingEnabled),
+		PkillSignal:             getSignalFromEnv("PKILL_SIGNAL", syscall.SIGINT, loggingEnabled),
+		PkillEscalationWait:     getDurationFromEnv("PKILL_ESCALATION_WAIT", 10*time.Second, loggingEnabled),
+		QuitVerifyTimeout:       getDurationFromEnv("QUIT_VERIFY_TIMEOUT", 5*time.Second, loggingEnabled),
+		QuitRetries:             getIntFromEnv("QUIT_RETRIES", 2, loggingEnabled),
+		SidecarQuitFallback:     getStringArrayFromEnv("SIDECAR_QUIT_FALLBACK", make([]string, 0), loggingEnabled),
+		ShutdownTimeout:         getDurationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second, loggingEnabled),
+		ShutdownCallTimeout:     getDurationFromEnv("SHUTDOWN_CALL_TIMEOUT", 5*time.Second, loggingEnabled),
+		ReapChildren:            getBoolFromEnv("REAP_CHILDREN", os.Getpid() == 1, loggingEnabled),
+		ChildProcessGroup:       getBoolFromEnv("CHILD_PROCESS_GROUP", false, loggingEnabled),
+		ChildGracePeriod:        getDurationFromEnv("CHILD_GRACE_PERIOD", time.Duration(0), loggingEnabled),
+		TerminationGracePeriod:  getDurationFromEnv("TERMINATION_GRACE_PERIOD", time.Duration(0), loggingEnabled),
+		ChildRetries:            getIntFromEnv("CHILD_RETRIES", 0, loggingEnabled),
+		ChildRetryInterval:      getDurationFromEnv("CHILD_RETRY_INTERVAL", time.Second, loggingEnabled),
+		CommandDelimiter:        getStringFromEnv("COMMAND_DELIMITER", "", loggingEnabled),
+		CommandFailureMode:      getChoiceFromEnv("COMMAND_FAILURE_MODE", CommandFailureStop, []string{CommandFailureStop, CommandFailureContinue}, loggingEnabled),
+		CommandMode:             getChoiceFromEnv("COMMAND_MODE", CommandModeSequence, []string{CommandModeSequence, CommandModeParallel}, loggingEnabled),
+		ParallelCompletion:      getChoiceFromEnv("PARALLEL_COMPLETION", ParallelCompletionMain, []string{ParallelCompletionMain, ParallelCompletionAll}, loggingEnabled),
+		MaxRuntime:              getDurationFromEnv("MAX_RUNTIME", time.Duration(0), loggingEnabled),
+		MaxRuntimeGracePeriod:   getDurationFromEnv("MAX_RUNTIME_GRACE_PERIOD", 10*time.Second, loggingEnabled),
+		ParallelExitCode:        getChoiceFromEnv("PARALLEL_EXIT_CODE", ParallelExitCodeMain, []string{ParallelExitCodeMain, ParallelExitCodeFirstFailure, ParallelExitCodeMax}, loggingEnabled),
+		ParallelStopGracePeriod: getDurationFromEnv("PARALLEL_STOP_GRACE_PERIOD", 10*time.Second, loggingEnabled),
+	}
+
+	endpoints, err := getQuitEndpointsFromEnv("GENERIC_QUIT_ENDPOINTS", "GENERIC_QUIT_ENDPOINTS_FILE", loggingEnabled)
+	if err != nil {
+		config.InvalidSettings = append(config.InvalidSettings, err.Error())
+	}
+	config.GenericQuitEndpoints = endpoints
+
+	if chain := strings.Trim(os.Getenv("SHUTDOWN_CHAIN"), " "); chain != "" {
+		steps, err := parseShutdownChain(chain, config)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("SHUTDOWN_CHAIN: %s", err))
+		} else if loggingEnabled {
+			log(fmt.Sprintf("SHUTDOWN_CHAIN: %s", chain))
+		}
+		config.ShutdownChain = steps
+	}
+
+	if policy := strings.Trim(os.Getenv("EXIT_CODE_POLICY"), " "); policy != "" {
+		rules, err := parseExitCodePolicy(policy)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("EXIT_CODE_POLICY: %s", err))
+		} else if loggingEnabled {
+			log(fmt.Sprintf("EXIT_CODE_POLICY: %s", policy))
+		}
+		config.ExitCodePolicy = rules
+	}
+
+	if path := strings.Trim(os.Getenv("COMMANDS_FILE"), " "); path != "" {
+		commands, err := getCommandsFromFile(path)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("COMMANDS_FILE: %s", err))
+		} else if loggingEnabled {
+			log(fmt.Sprintf("COMMANDS_FILE: %s (%d commands)", path, len(commands)))
+		}
+		config.Commands = commands
+	}
+
+	if exitCodes := strings.Trim(os.Getenv("CHILD_RETRY_EXIT_CODES"), " "); exitCodes != "" {
+		rule, err := parseChildRetryExitCodes(exitCodes)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("CHILD_RETRY_EXIT_CODES: %s", err))
+		} else if loggingEnabled {
+			log(fmt.Sprintf("CHILD_RETRY_EXIT_CODES: %s", exitCodes))
+		}
+		config.ChildRetryExitCodes = rule
+	}
+
+	if signalMap := strings.Trim(os.Getenv("SIGNAL_MAP"), " "); signalMap != "" {
+		translations, err := parseSignalMap(signalMap)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("SIGNAL_MAP: %s", err))
+		} else if loggingEnabled {
+			log(fmt.Sprintf("SIGNAL_MAP: %s", signalMap))
+		}
+		config.SignalMap = translations
+	}
+
+	if signalDrop := strings.Trim(os.Getenv("SIGNAL_DROP"), " "); signalDrop != "" {
+		dropped, err := parseSignalDrop(signalDrop)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("SIGNAL_DROP: %s", err))
+		} else if loggingEnabled {
+			log(fmt.Sprintf("SIGNAL_DROP: %s", signalDrop))
+		}
+		config.SignalDrop = dropped
+	}
+
+	if path := strings.Trim(os.Getenv("TERMINATION_GRACE_PERIOD_FILE"), " "); path != "" {
+		grace, err := getGracePeriodFromFile(path)
+		if err != nil {
+			config.InvalidSettings = append(config.InvalidSettings, fmt.Sprintf("TERMINATION_GRACE_PERIOD_FILE: %s", err))
+		} else {
+			if loggingEnabled {
+				log(fmt.Sprintf("TERMINATION_GRACE_PERIOD_FILE: %s (%s)", path, grace))
+			}
+			config.TerminationGracePeriod = grace
+		}
+	}
+
+	// SHUTDOWN_TIMEOUT is reserved out of the termination grace period for stopping the sidecars
+	if config.TerminationGracePeriod > time.Duration(0) {
+		if config.TerminationGracePeriod <= config.ShutdownTimeout {
+			// Such as Kubernetes' default of 30s with the default SHUTDOWN_TIMEOUT, the child still needs some of it
+			shutdownTimeout := config.TerminationGracePeriod / 3
+			config.Warnings = append(config.Warnings, fmt.Sprintf("TERMINATION_GRACE_PERIOD: %s leaves no time for the child after reserving SHUTDOWN_TIMEOUT (%s), reserving %s for the sidecars instead", config.TerminationGracePeriod, config.ShutdownTimeout, shutdownTimeout))
+			config.ShutdownTimeout = shutdownTimeout
+		}
+		childGrace := config.TerminationGracePeriod - config.ShutdownTimeout
+		if config.ChildGracePeriod <= time.Duration(0) || config.ChildGracePeriod > childGrace {
+			config.ChildGracePeriod = childGrace
+		}
 	}
 
 	return config
@@ -76,6 +271,29 @@ func getStringFromEnv(name string, defaultVal string, logEnabled bool) string {
 	return defaultVal
 }
 
+func getChoiceFromEnv(name string, defaultVal string, choices []string, logEnabled bool) string {
+	userVal := strings.Trim(os.Getenv(name), " ")
+	// User did not set anything return default
+	if userVal == "" {
+		return defaultVal
+	}
+
+	// User set something, check it is one of the allowed values
+	for _, choice := range choices {
+		if userVal == choice {
+			if logEnabled {
+				log(fmt.Sprintf("%s: %s", name, userVal))
+			}
+			return userVal
+		}
+	}
+
+	if logEnabled {
+		log(fmt.Sprintf("%s: %s (Invalid value will be ignored)", name, userVal))
+	}
+	return defaultVal
+}
+
 func getBoolFromEnv(name string, defaultVal bool, logEnabled bool) bool {
 	userVal := os.Getenv(name)
 	// User did not set anything return default
@@ -98,6 +316,52 @@ func getBoolFromEnv(name string, defaultVal bool, logEnabled bool) bool {
 	return userVal == "true"
 }
 
+func getIntFromEnv(name string, defaultVal int, logEnabled bool) int {
+	userVal := strings.Trim(os.Getenv(name), " ")
+
+	// User did not set anything, return default.
+	if userVal == "" {
+		return defaultVal
+	}
+
+	// User has set something, check it is valid.
+	intVal, err := strconv.Atoi(userVal)
+	if err != nil {
+		if logEnabled {
+			log(fmt.Sprintf("%s: %s (Invalid value will be ignored)", name, userVal))
+		}
+		return defaultVal
+	}
+
+	if logEnabled {
+		log(fmt.Sprintf("%s: %s", name, userVal))
+	}
+	return intVal
+}
+
+func getSignalFromEnv(name string, defaultVal syscall.Signal, logEnabled bool) syscall.Signal {
+	userVal := strings.Trim(os.Getenv(name), " ")
+
+	// User did not set anything, return default.
+	if userVal == "" {
+		return defaultVal
+	}
+
+	// User has set something, check it is valid.
+	sig, err := parseSignal(userVal)
+	if err != nil {
+		if logEnabled {
+			log(fmt.Sprintf("%s: %s (Invalid value will be ignored)", name, userVal))
+		}
+		return defaultVal
+	}
+
+	if logEnabled {
+		log(fmt.Sprintf("%s: %s", name, userVal))
+	}
+	return sig
+}
+
 func getDurationFromEnv(name string, defaultVal time.Duration, logEnabled bool) time.Duration {
 	userVal := os.Getenv(name)
 
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Strategies that can be used in SHUTDOWN_CHAIN
+const (
+	// chainEnvoyAdminQuit ... POST /quitquitquit to ENVOY_ADMIN_API
+	chainEnvoyAdminQuit = "envoy-admin-quit"
+	// chainIstioAgentQuit ... POST /quitquitquit to ISTIO_QUIT_API
+	chainIstioAgentQuit = "istio-agent-quit"
+	// chainLinkerdShutdown ... POST /shutdown to LINKERD_ADMIN_API
+	chainLinkerdShutdown = "linkerd-shutdown"
+	// chainGeneric ... generic:<url>, a POST to the URL that must return a 2xx status code
+	chainGeneric = "generic"
+	// chainSignal ... signal:<process>[:<signal>], signals the process like pkill, SIGTERM by default
+	chainSignal = "signal"
+)
+
+// ShutdownStep ... one step of SHUTDOWN_CHAIN, such as `signal:pilot-agent:SIGTERM;timeout=5s;always`
+type ShutdownStep struct {
+	Spec     string
+	Strategy string
+	// Target ... the URL of a generic step, or the process name of a signal step
+	Target string
+	Signal syscall.Signal
+	// Timeout ... limits how long the step can take, no limit when 0
+	Timeout time.Duration
+	// Always ... the step runs even when an earlier step stopped the sidecars, and does not end the chain
+	Always bool
+}
+
+// parseShutdownChain ... parses the CSV of SHUTDOWN_CHAIN steps, checking each strategy has what it needs in cfg
+func parseShutdownChain(chain string, cfg ScuttleConfig) ([]ShutdownStep, error) {
+	steps := make([]ShutdownStep, 0)
+	for i, spec := range strings.Split(chain, ",") {
+		step, err := parseShutdownStep(strings.Trim(spec, " "), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("step %d '%s': %w", i+1, strings.Trim(spec, " "), err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func parseShutdownStep(spec string, cfg ScuttleConfig) (ShutdownStep, error) {
+	step := ShutdownStep{Spec: spec}
+	parts := strings.Split(spec, ";")
+	for _, option := range parts[1:] {
+		option = strings.Trim(option, " ")
+		switch {
+		case option == "always":
+			step.Always = true
+		case strings.HasPrefix(option, "timeout="):
+			timeout, err := time.ParseDuration(strings.TrimPrefix(option, "timeout="))
+			if err != nil || timeout <= time.Duration(0) {
+				return step, fmt.Errorf("invalid timeout '%s'", option)
+			}
+			step.Timeout = timeout
+		default:
+			return step, fmt.Errorf("unknown option '%s'", option)
+		}
+	}
+
+	strategy := strings.Trim(parts[0], " ")
+	switch {
+	case strategy == "":
+		return step, errors.New("empty step")
+	case strategy == chainEnvoyAdminQuit && cfg.EnvoyAdminAPI == "":
+		return step, errors.New("ENVOY_ADMIN_API is not set")
+	case strategy == chainIstioAgentQuit && cfg.IstioQuitAPI == "":
+		return step, errors.New("ISTIO_QUIT_API is not set")
+	case strategy == chainLinkerdShutdown && cfg.LinkerdAdminAPI == "":
+		return step, errors.New("LINKERD_ADMIN_API is not set")
+	case strategy == chainEnvoyAdminQuit || strategy == chainIstioAgentQuit || strategy == chainLinkerdShutdown:
+		step.Strategy = strategy
+	case strings.HasPrefix(strategy, chainGeneric+":"):
+		step.Strategy = chainGeneric
+		step.Target = strings.TrimPrefix(strategy, chainGeneric+":")
+		if u, err := url.Parse(step.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return step, fmt.Errorf("invalid URL '%s'", step.Target)
+		}
+	case strings.HasPrefix(strategy, chainSignal+":"):
+		step.Strategy = chainSignal
+		step.Signal = syscall.SIGTERM
+		target := strings.SplitN(strings.TrimPrefix(strategy, chainSignal+":"), ":", 2)
+		step.Target = target[0]
+		if step.Target == "" {
+			return step, errors.New("missing process name")
+		}
+		if len(target) == 2 {
+			sig, err := parseSignal(target[1])
+			if err != nil {
+				return step, err
+			}
+			step.Signal = sig
+		}
+	default:
+		return step, fmt.Errorf("unknown strategy '%s'", strategy)
+	}
+	return step, nil
+}
+
+// runShutdownChain ... runs the steps in order. Once a step succeeds the remaining steps
+// are skipped, except for the ones marked always. Returns an error if no step succeeded.
+func runShutdownChain(ctx context.Context, cfg ScuttleConfig, steps []ShutdownStep, exitCode int) error {
+	drainEnvoy(ctx, cfg)
+
+	stopped := false
+	for i, step := range steps {
+		if stopped && !step.Always {
+			log(fmt.Sprintf("Shutdown step %d '%s' skipped, the sidecars are stopped", i+1, step.Spec))
+			continue
+		}
+
+		err := runShutdownStep(ctx, cfg, step, exitCode)
+		if err != nil {
+			log(fmt.Sprintf("Shutdown step %d '%s' failed, error: %s", i+1, step.Spec, err))
+			continue
+		}
+		log(fmt.Sprintf("Shutdown step %d '%s' succeeded", i+1, step.Spec))
+		if !step.Always {
+			stopped = true
+		}
+	}
+
+	if !stopped {
+		return errors.New("no shutdown step stopped the sidecars")
+	}
+	return nil
+}
+
+func runShutdownStep(ctx context.Context, cfg ScuttleConfig, step ShutdownStep, exitCode int) error {
+	if step.Timeout > time.Duration(0) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	switch step.Strategy {
+	case chainEnvoyAdminQuit:
+		if err := requireLocalAPI("ENVOY_ADMIN_API", cfg.EnvoyAdminAPI); err != nil {
+			return err
+		}
+		return stopSidecar(ctx, cfg, "Envoy", envoyLiveURL(cfg), envoyQuitStrategies(cfg, QuitMethodEnvoyAPI, nil))
+	case chainIstioAgentQuit:
+		if err := requireLocalAPI("ENVOY_ADMIN_API", cfg.EnvoyAdminAPI); err != nil {
+			return err
+		}
+		return stopSidecar(ctx, cfg, "Istio", envoyLiveURL(cfg), envoyQuitStrategies(cfg, QuitMethodIstioAPI, nil))
+	case chainLinkerdShutdown:
+		return newLinkerdDriver(cfg).Shutdown(ctx, exitCode)
+	case chainGeneric:
+		return callQuitEndpoint(ctx, cfg, QuitEndpoint{URL: step.Target})
+	case chainSignal:
+		return stopProcesses(ctx, step.Target, step.Signal, cfg.PkillEscalationWait)
+	default:
+		return fmt.Errorf("unknown strategy '%s'", step.Strategy)
+	}
+}
+
+// shutdownChainNames ... the steps of the chain, for logging
+func shutdownChainNames(steps []ShutdownStep) string {
+	specs := make([]string, 0, len(steps))
+	for _, step := range steps {
+		specs = append(specs, step.Spec)
+	}
+	return strings.Join(specs, ", ")
+}
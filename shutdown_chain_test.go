@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Tests SHUTDOWN_CHAIN steps are parsed, and misconfigured chains are rejected
+func TestParseShutdownChain(t *testing.T) {
+	fmt.Println("Starting TestParseShutdownChain")
+	cfg := ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", IstioQuitAPI: "http://127.0.0.1:15020"}
+	steps, err := parseShutdownChain("envoy-admin-quit, istio-agent-quit;timeout=5s, generic:http://127.0.0.1:8080/quit;always, signal:pilot-agent:SIGINT;timeout=2s;always", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ShutdownStep{
+		{Spec: "envoy-admin-quit", Strategy: chainEnvoyAdminQuit},
+		{Spec: "istio-agent-quit;timeout=5s", Strategy: chainIstioAgentQuit, Timeout: 5 * time.Second},
+		{Spec: "generic:http://127.0.0.1:8080/quit;always", Strategy: chainGeneric, Target: "http://127.0.0.1:8080/quit", Always: true},
+		{Spec: "signal:pilot-agent:SIGINT;timeout=2s;always", Strategy: chainSignal, Target: "pilot-agent", Signal: syscall.SIGINT, Timeout: 2 * time.Second, Always: true},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, steps)
+	}
+
+	invalid := []string{
+		"",
+		"envoy-admin-quit,,generic:http://127.0.0.1:8080",
+		"idontexist",
+		"linkerd-shutdown",
+		"generic:notaurl^^",
+		"signal:",
+		"signal:pilot-agent:SIGNOPE",
+		"envoy-admin-quit;timeout=soon",
+		"envoy-admin-quit;sometimes",
+	}
+	for _, chain := range invalid {
+		if _, err := parseShutdownChain(chain, cfg); err == nil {
+			t.Errorf("%s: expected the chain to be rejected", chain)
+		}
+	}
+}
+
+// Tests steps stop the chain once one succeeds, except for the ones marked always
+func TestRunShutdownChain(t *testing.T) {
+	fmt.Println("Starting TestRunShutdownChain")
+	var failing, succeeding, always int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/failing":
+			atomic.AddInt32(&failing, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/succeeding":
+			atomic.AddInt32(&succeeding, 1)
+		case "/always":
+			atomic.AddInt32(&always, 1)
+		}
+	}))
+	defer server.Close()
+
+	chain := fmt.Sprintf("generic:%[1]s/failing, generic:%[1]s/succeeding;timeout=1s, generic:%[1]s/succeeding, generic:%[1]s/always;always", server.URL)
+	steps, err := parseShutdownChain(chain, ScuttleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runShutdownChain(context.Background(), ScuttleConfig{}, steps, 0); err != nil {
+		t.Fatalf("Expected the chain to succeed, got: %s", err)
+	}
+	if failing != 1 || succeeding != 1 || always != 1 {
+		t.Fatalf("Expected each endpoint to be called once, got %d, %d and %d", failing, succeeding, always)
+	}
+
+	steps, _ = parseShutdownChain(fmt.Sprintf("generic:%[1]s/failing, generic:%[1]s/always;always", server.URL), ScuttleConfig{})
+	if err := runShutdownChain(context.Background(), ScuttleConfig{}, steps, 0); err == nil {
+		t.Fatal("Expected an error when only an always step succeeded")
+	}
+}
+
+// Tests scuttle refuses to start with an invalid SHUTDOWN_CHAIN, but still stops Envoy with the default drivers
+func TestInvalidShutdownChain(t *testing.T) {
+	fmt.Println("Starting TestInvalidShutdownChain")
+	initTestingEnv()
+	before := atomic.LoadInt32(&envoyQuitRequests)
+	env := []string{"START_WITHOUT_ENVOY=true", "ENVOY_ADMIN_API=" + envoyQuitServer.URL, "ISTIO_QUIT_API=", "SIDECAR_DRIVERS=", "SHUTDOWN_CHAIN=envoy-admin-quit, idontexist"}
+	cmd := startScuttle(t, env, "starting up", "echo", "child should not run")
+	if exitCode := waitForScuttle(t, cmd); exitCode != exitCodeInvalidConfig {
+		t.Fatalf("Expected exit code %d, got %d", exitCodeInvalidConfig, exitCode)
+	}
+	if atomic.LoadInt32(&envoyQuitRequests) != before+1 {
+		t.Fatal("Envoy did not receive quitquitquit")
+	}
+}
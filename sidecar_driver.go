@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cenk/backoff"
+)
+
+// SidecarDriver ... knows how to wait for a sidecar to become ready and how to stop it
+type SidecarDriver interface {
+	// Name ... the name the driver is registered and configured with in SIDECAR_DRIVERS
+	Name() string
+	// WaitReady ... blocks until the sidecar is ready or ctx is done.
+	// Returns errNoReadinessCheck if the driver has nothing to wait for.
+	WaitReady(ctx context.Context) error
+	// Shutdown ... stops the sidecar, exitCode is the exit code of the child process
+	Shutdown(ctx context.Context, exitCode int) error
+}
+
+// errNoReadinessCheck ... returned by WaitReady when a driver only handles shutdown
+var errNoReadinessCheck = errors.New("driver has no readiness check")
+
+// driverFactories ... registered drivers, keyed by the name used in SIDECAR_DRIVERS
+var driverFactories = map[string]func(ScuttleConfig) SidecarDriver{}
+
+// registerDriver ... makes a driver available to SIDECAR_DRIVERS, called from each driver's init()
+func registerDriver(name string, factory func(ScuttleConfig) SidecarDriver) {
+	if _, exists := driverFactories[name]; exists {
+		panic(fmt.Sprintf("sidecar driver '%s' registered twice", name))
+	}
+	driverFactories[name] = factory
+}
+
+// getDrivers ... builds the drivers named in SIDECAR_DRIVERS, or the default drivers if it is not set
+func getDrivers(cfg ScuttleConfig) []SidecarDriver {
+	names := cfg.SidecarDrivers
+	if len(names) == 0 {
+		names = defaultDriverNames(cfg)
+	}
+
+	drivers := make([]SidecarDriver, 0, len(names))
+	for _, name := range names {
+		name = strings.Trim(name, " ")
+		factory, ok := driverFactories[name]
+		if !ok {
+			log(fmt.Sprintf("Unknown sidecar driver '%s' will be ignored", name))
+			continue
+		}
+		drivers = append(drivers, factory(cfg))
+	}
+	return drivers
+}
+
+// defaultDriverNames ... picks drivers based on the Envoy, Istio and Linkerd settings when SIDECAR_DRIVERS is not set
+func defaultDriverNames(cfg ScuttleConfig) []string {
+	if cfg.EnvoyAdminAPI == "" && cfg.IstioReadyAPI == "" && cfg.LinkerdAdminAPI == "" {
+		return nil
+	}
+
+	names := make([]string, 0, 3)
+	if len(cfg.GenericQuitEndpoints) > 0 {
+		names = append(names, "generic")
+	}
+
+	switch {
+	case cfg.EnvoyAdminAPI == "" && cfg.IstioReadyAPI != "":
+		names = append(names, "istio")
+	case cfg.EnvoyAdminAPI == "":
+	case cfg.SidecarQuitMethod == QuitMethodEnvoyAPI:
+		names = append(names, "envoy")
+	case cfg.SidecarQuitMethod == QuitMethodAuto && cfg.IstioQuitAPI == "":
+		names = append(names, "envoy")
+	default:
+		names = append(names, "istio")
+	}
+
+	if cfg.LinkerdAdminAPI != "" {
+		names = append(names, "linkerd")
+	}
+	return names
+}
+
+// readinessBackOff ... the backoff used while waiting for a sidecar, it gives up with
+// QUIT_WITHOUT_ENVOY_TIMEOUT or WAIT_FOR_ENVOY_TIMEOUT, otherwise it retries until ctx is done
+func readinessBackOff(ctx context.Context, cfg ScuttleConfig) backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	// We wait forever for envoy to start. In practice k8s will kill the pod if we take too long.
+	b.MaxElapsedTime = cfg.WaitForEnvoyTimeout
+
+	if cfg.QuitWithoutEnvoyTimeout > time.Duration(0) {
+		b.MaxElapsedTime = cfg.QuitWithoutEnvoyTimeout
+	}
+	return backoff.WithContext(b, ctx)
+}
+
+// isLocalAPI ... scuttle only stops sidecars running on localhost or 127.0.0.1
+func isLocalAPI(api string) bool {
+	return strings.Contains(api, "127.0.0.1") || strings.Contains(api, "localhost")
+}
+
+// requireLocalAPI ... an error when the API in setting is set but not local, so the sidecar is left running
+func requireLocalAPI(setting string, api string) error {
+	if api == "" || isLocalAPI(api) {
+		return nil
+	}
+	return fmt.Errorf("%s is not a localhost or 127.0.0.1, skipping", setting)
+}
+
+func driverNames(drivers []SidecarDriver) string {
+	names := make([]string, 0, len(drivers))
+	for _, driver := range drivers {
+		names = append(names, driver.Name())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeDriver ... records the calls made to it by scuttle's lifecycle
+type fakeDriver struct {
+	ready    bool
+	shutdown []int
+}
+
+var lastFakeDriver *fakeDriver
+
+func init() {
+	registerDriver("fake", func(cfg ScuttleConfig) SidecarDriver {
+		lastFakeDriver = &fakeDriver{}
+		return lastFakeDriver
+	})
+}
+
+// blockingDriver ... never finishes shutting down, ignoring its context
+type blockingDriver struct{}
+
+func init() {
+	registerDriver("blocking", func(cfg ScuttleConfig) SidecarDriver {
+		return blockingDriver{}
+	})
+}
+
+func (blockingDriver) Name() string {
+	return "blocking"
+}
+
+func (blockingDriver) WaitReady(ctx context.Context) error {
+	return errNoReadinessCheck
+}
+
+func (blockingDriver) Shutdown(ctx context.Context, exitCode int) error {
+	select {}
+}
+
+func (d *fakeDriver) Name() string {
+	return "fake"
+}
+
+func (d *fakeDriver) WaitReady(ctx context.Context) error {
+	d.ready = true
+	return nil
+}
+
+func (d *fakeDriver) Shutdown(ctx context.Context, exitCode int) error {
+	d.shutdown = append(d.shutdown, exitCode)
+	return nil
+}
+
+// Tests the drivers picked when SIDECAR_DRIVERS is not set
+func TestDefaultDriverNames(t *testing.T) {
+	fmt.Println("Starting TestDefaultDriverNames")
+	tests := []struct {
+		name     string
+		cfg      ScuttleConfig
+		expected []string
+	}{
+		{"no admin API", ScuttleConfig{IstioQuitAPI: "http://127.0.0.1:15020"}, nil},
+		{"plain envoy", ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", SidecarQuitMethod: QuitMethodAuto}, []string{"envoy"}},
+		{"istio API", ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", IstioQuitAPI: "http://127.0.0.1:15020", SidecarQuitMethod: QuitMethodAuto}, []string{"istio"}},
+		{"pkill", ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", SidecarQuitMethod: QuitMethodPkill}, []string{"istio"}},
+		{"envoy API with istio", ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", IstioQuitAPI: "http://127.0.0.1:15020", SidecarQuitMethod: QuitMethodEnvoyAPI}, []string{"envoy"}},
+		{"istio readiness with envoy API", ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", IstioReadyAPI: "http://127.0.0.1:15021", SidecarQuitMethod: QuitMethodAuto}, []string{"envoy"}},
+		{"istio agent only", ScuttleConfig{IstioReadyAPI: "http://127.0.0.1:15021", SidecarQuitMethod: QuitMethodAuto}, []string{"istio"}},
+		{"generic", ScuttleConfig{EnvoyAdminAPI: "http://127.0.0.1:15000", GenericQuitEndpoints: []QuitEndpoint{{URL: "http://127.0.0.1:8080"}}, SidecarQuitMethod: QuitMethodAuto}, []string{"generic", "envoy"}},
+	}
+	for _, test := range tests {
+		if names := defaultDriverNames(test.cfg); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s: expected drivers %v, got %v", test.name, test.expected, names)
+		}
+	}
+}
+
+// Tests unknown names in SIDECAR_DRIVERS are ignored
+func TestUnknownDriverIgnored(t *testing.T) {
+	fmt.Println("Starting TestUnknownDriverIgnored")
+	drivers := getDrivers(ScuttleConfig{LoggingEnabled: true, SidecarDrivers: []string{"idontexist", " envoy"}})
+	if len(drivers) != 1 || drivers[0].Name() != "envoy" {
+		t.Fatalf("Expected only the envoy driver, got '%s'", driverNames(drivers))
+	}
+}
+
+// Tests the lifecycle waits on and stops a registered driver chosen with SIDECAR_DRIVERS
+func TestRegisteredDriverLifecycle(t *testing.T) {
+	fmt.Println("Starting TestRegisteredDriverLifecycle")
+	config = ScuttleConfig{LoggingEnabled: true, SidecarDrivers: []string{"fake"}}
+
+	blockingCtx := waitForEnvoy(context.Background())
+	if blockingCtx == nil {
+		t.Fatal("Blocking context was nil")
+	}
+	select {
+	case <-time.After(1 * time.Second):
+		t.Fatal("Blocking did not finish")
+	case <-blockingCtx.Done():
+	}
+	if !lastFakeDriver.ready {
+		t.Fatal("Driver was not waited on")
+	}
+
+	kill(3, 0)
+	if !reflect.DeepEqual(lastFakeDriver.shutdown, []int{3}) {
+		t.Fatalf("Expected driver to be shut down once with exit code 3, got %v", lastFakeDriver.shutdown)
+	}
+}
+
+// Tests kill() returns once SHUTDOWN_TIMEOUT is used up, even if a driver never finishes
+func TestShutdownBudget(t *testing.T) {
+	fmt.Println("Starting TestShutdownBudget")
+	config = ScuttleConfig{LoggingEnabled: true, SidecarDrivers: []string{"blocking"}, ShutdownTimeout: 300 * time.Millisecond}
+
+	done := make(chan struct{})
+	go func() {
+		kill(0, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("kill() did not return within the shutdown budget")
+	}
+}
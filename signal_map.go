@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// parseSignalMap ... parses SIGNAL_MAP, a CSV of `<received>:<forwarded>` signals such as `TERM:QUIT,HUP:USR1`
+func parseSignalMap(signalMap string) (map[syscall.Signal]syscall.Signal, error) {
+	translations := make(map[syscall.Signal]syscall.Signal)
+	for _, entry := range strings.Split(signalMap, ",") {
+		entry = strings.Trim(entry, " ")
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("'%s': expected <received>:<forwarded>", entry)
+		}
+		received, err := parseReceivableSignal(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", entry, err)
+		}
+		forwarded, err := parseSignal(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", entry, err)
+		}
+		if _, ok := translations[received]; ok {
+			return nil, fmt.Errorf("'%s': %s is mapped more than once", entry, signalName(received))
+		}
+		translations[received] = forwarded
+	}
+	return translations, nil
+}
+
+// parseSignalDrop ... parses SIGNAL_DROP, a CSV of signals that are not forwarded such as `WINCH,CHLD`
+func parseSignalDrop(signalDrop string) ([]syscall.Signal, error) {
+	dropped := make([]syscall.Signal, 0)
+	for _, name := range strings.Split(signalDrop, ",") {
+		sig, err := parseReceivableSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		dropped = append(dropped, sig)
+	}
+	return dropped, nil
+}
+
+// parseReceivableSignal ... like parseSignal, rejecting the signals scuttle can never receive
+func parseReceivableSignal(name string) (syscall.Signal, error) {
+	sig, err := parseSignal(name)
+	if err != nil {
+		return 0, err
+	}
+	if sig == syscall.SIGKILL || sig == syscall.SIGSTOP {
+		return 0, errors.New(signalName(sig) + " cannot be received")
+	}
+	return sig, nil
+}
+
+// forwardedSignal ... the signal passed to the child for a received signal, following SIGNAL_DROP and SIGNAL_MAP.
+// False when the signal is dropped.
+func forwardedSignal(cfg ScuttleConfig, sig syscall.Signal) (syscall.Signal, bool) {
+	for _, dropped := range cfg.SignalDrop {
+		if sig == dropped {
+			return 0, false
+		}
+	}
+	if forwarded, ok := cfg.SignalMap[sig]; ok {
+		return forwarded, true
+	}
+	return sig, true
+}
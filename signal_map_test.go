@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// Tests SIGNAL_MAP and SIGNAL_DROP are parsed, and invalid signals rejected
+func TestParseSignalMap(t *testing.T) {
+	fmt.Println("Starting TestParseSignalMap")
+	translations, err := parseSignalMap("TERM:QUIT, SIGHUP:usr1,2:15")
+	expected := map[syscall.Signal]syscall.Signal{
+		syscall.SIGTERM: syscall.SIGQUIT,
+		syscall.SIGHUP:  syscall.SIGUSR1,
+		syscall.SIGINT:  syscall.SIGTERM,
+	}
+	if err != nil || !reflect.DeepEqual(translations, expected) {
+		t.Fatalf("Expected %v, got %v (%v)", expected, translations, err)
+	}
+	for _, signalMap := range []string{"TERM", "TERM:", "TERM:QUIT:INT", "NOPE:QUIT", "TERM:NOPE", "KILL:TERM", "TERM:QUIT,TERM:INT"} {
+		if _, err := parseSignalMap(signalMap); err == nil {
+			t.Errorf("%s: expected the map to be rejected", signalMap)
+		}
+	}
+
+	dropped, err := parseSignalDrop("WINCH, SIGCHLD")
+	if err != nil || !reflect.DeepEqual(dropped, []syscall.Signal{syscall.SIGWINCH, syscall.SIGCHLD}) {
+		t.Fatalf("Expected SIGWINCH and SIGCHLD, got %v (%v)", dropped, err)
+	}
+	for _, signalDrop := range []string{"WINCH,", "NOPE", "STOP"} {
+		if _, err := parseSignalDrop(signalDrop); err == nil {
+			t.Errorf("%s: expected the drop list to be rejected", signalDrop)
+		}
+	}
+}
+
+// Tests dropped signals are not forwarded, and mapped ones are translated
+func TestForwardedSignal(t *testing.T) {
+	fmt.Println("Starting TestForwardedSignal")
+	cfg := ScuttleConfig{
+		SignalMap:  map[syscall.Signal]syscall.Signal{syscall.SIGTERM: syscall.SIGQUIT, syscall.SIGWINCH: syscall.SIGUSR1},
+		SignalDrop: []syscall.Signal{syscall.SIGWINCH},
+	}
+	tests := []struct {
+		received  syscall.Signal
+		forwarded syscall.Signal
+		ok        bool
+	}{
+		{syscall.SIGTERM, syscall.SIGQUIT, true},
+		{syscall.SIGINT, syscall.SIGINT, true},
+		{syscall.SIGWINCH, 0, false},
+	}
+	for _, test := range tests {
+		if forwarded, ok := forwardedSignal(cfg, test.received); forwarded != test.forwarded || ok != test.ok {
+			t.Errorf("%s: expected (%s, %v), got (%s, %v)", signalName(test.received), signalName(test.forwarded), test.ok, signalName(forwarded), ok)
+		}
+	}
+}